@@ -14,24 +14,39 @@ type Block struct {
 	PrevBlockHash []byte
 	Hash          []byte
 	Nonce         int
+	Bits          int
 	Height        int
+	// Commit holds the precommits of the validators that committed a block
+	// of Tendermint, it is not covered by the hash
+	Commit []precommit
 }
 
-// NewBlock creates and returns Block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height}
-	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
-
-	block.Hash = hash[:]
-	block.Nonce = nonce
+// NewBlock creates and returns Block, mined with the difficulty bits
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height, bits int) *Block {
+	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, bits, height, nil}
+	block.mine(nil)
 
 	return block
 }
 
+// mine finds the nonce and the hash of the block, it returns false if
+// cancel is closed first
+func (b *Block) mine(cancel <-chan struct{}) bool {
+	pow := NewProofOfWork(b)
+	nonce, hash, ok := pow.Mine(cancel)
+	if !ok {
+		return false
+	}
+
+	b.Hash = hash
+	b.Nonce = nonce
+
+	return true
+}
+
 // NewGenesisBlock creates and returns genesis Block
 func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, initialBits)
 }
 
 // HashTransactions returns a hash of the transactions in the block
@@ -18,8 +18,9 @@ const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second
 
 // Blockchain implements interactions with a DB
 type Blockchain struct {
-	tip []byte
-	db  *bolt.DB
+	tip       []byte
+	db        *bolt.DB
+	consensus ConsensusEngine
 }
 
 func (bchain *Blockchain) CloseDB() {
@@ -36,7 +37,7 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 
 	var tip []byte
 
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData, 0, nil, 0, 37)
+	cbtx := NewCoinbaseTX(address, genesisCoinbaseData, 0, nil, Coordinate{}, 37, 0)
 	genesis := NewGenesisBlock(cbtx)
 
 	db, err := bolt.Open(dbFile, 0600, nil)
@@ -61,13 +62,14 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 		}
 		tip = genesis.Hash
 
-		return nil
+		_, err = indexChain(tx)
+		return err
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{tip, db, powEngine{}}
 
 	return &bc
 }
@@ -88,122 +90,57 @@ func NewBlockchain(nodeID string) *Blockchain {
 
 	err = db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		// the value is only valid during the transaction
+		tip = append([]byte{}, b.Get([]byte("l"))...)
 
+		// a blockchain created before the indexes is indexed once
+		if tx.Bucket([]byte(txIndexBucket)) == nil || tx.Bucket([]byte(heightIndexBucket)) == nil {
+			_, err := indexChain(tx)
+			return err
+		}
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{tip, db, powEngine{}}
+	if (URPOSet{&bc}).upgrade() {
+		fmt.Println("The URPO set was rebuilt for the outpoint layout")
+	}
 
 	return &bc
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
+// FindTransaction finds a transaction by its ID
+func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	var found *Transaction
 
-		if blockInDb != nil {
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(txIndexBucket)).Get(ID)
+		if v == nil {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
-
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			bc.tip = block.Hash
-		}
+		blockHash, pos := parseTxIndexValue(v)
+		block := DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(blockHash))
+		found = block.Transactions[pos]
 
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
-}
 
-// FindTransaction finds a transaction by its ID
-func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
-
-	for {
-		block := bci.Next()
-
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
-
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	if found == nil {
+		return Transaction{}, errors.New("Transaction is not found")
 	}
-
-	return Transaction{}, errors.New("Transaction is not found")
-}
-
-// FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
-func (bc *Blockchain) FindURPO() map[string]TXOutputs {
-	URPO := make(map[string]TXOutputs)
-	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
-
-	for {
-		block := bci.Next()
-
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Vout {
-				// Was the output spent?
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				outs := URPO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				URPO[txID] = outs
-			}
-
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Vin {
-					inTxID := hex.EncodeToString(in.Txid)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-				}
-			}
-		}
-
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
-	}
-
-	return URPO
+	return *found, nil
 }
 
 // Iterator returns a BlockchainIterat
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	bci := &BlockchainIterator{bc.tip, bc.db}
+	bci := &BlockchainIterator{bc.currentTip(), bc.db}
 
 	return bci
 }
@@ -269,37 +206,81 @@ func (bc *Blockchain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
-// VerifyBlock verifies block prevhash
+// VerifyBlock verifies block prevhash and transactions
 func (bc *Blockchain) VerifyBlock(block *Block) bool {
-	if bytes.Compare(bc.tip, block.PrevBlockHash) != 0 {
-		fmt.Printf("%x !!! %x ~~~ %x\n", bc.tip, block.PrevBlockHash, block.Hash)
+	if tip := bc.currentTip(); bytes.Compare(tip, block.PrevBlockHash) != 0 {
+		fmt.Printf("%x !!! %x ~~~ %x\n", tip, block.PrevBlockHash, block.Hash)
 		return false
-	} else {
-		return true
 	}
+	err := bc.ValidateBlock(block)
+	if err != nil {
+		fmt.Printf("Invalid block %x: %s\n", block.Hash, err)
+		return false
+	}
+	return true
 	//return bytes.Compare(bc.tip, block.PrevBlockHash) == 0
 }
 
-// MineBlock mines a new block with the provided transactions
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
+// errMiningCancelled is returned when a competing block stops the mining
+var errMiningCancelled = errors.New("mining was cancelled")
+
+// MineBlock mines a new block with the provided transactions,
+// it fails if any of the transactions is invalid
+func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
+	return bc.MineBlockUntil(transactions, nil)
+}
+
+// MineBlockUntil is MineBlock giving up once cancel is closed
+func (bc *Blockchain) MineBlockUntil(transactions []*Transaction, cancel <-chan struct{}) (*Block, error) {
+	newBlock, err := bc.prepareBlock(transactions)
+	if err != nil {
+		return nil, err
+	}
+	if !newBlock.mine(cancel) {
+		return nil, errMiningCancelled
+	}
+
+	return newBlock, nil
+}
+
+// SealBlock makes a new block with the provided transactions without
+// proof-of-work, for the engines that agree on blocks otherwise
+func (bc *Blockchain) SealBlock(transactions []*Transaction) (*Block, error) {
+	newBlock, err := bc.prepareBlock(transactions)
+	if err != nil {
+		return nil, err
+	}
+	newBlock.Bits = 0
+	newBlock.Hash = NewProofOfWork(newBlock).hash(0)
+
+	return newBlock, nil
+}
+
+// prepareBlock returns a block of the transactions on top of the tip,
+// with the difficulty bits of the chain and without a hash
+func (bc *Blockchain) prepareBlock(transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
-	var lastHeight int
+	var lastHeight, bits int
+	var timestamp int64
 
+	v := NewTxValidator(bc)
 	for _, tx := range transactions {
-		// TODO: ignore transaction if it's not valid
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
+		err := v.Accept(tx)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s transaction %x: %s", tx.Type, tx.ID, err)
 		}
 	}
 
 	err := bc.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
 
 		blockData := b.Get(lastHash)
 		block := DeserializeBlock(blockData)
 
 		lastHeight = block.Height
+		bits = nextBits(b, block)
+		timestamp = nextTimestamp(b, block)
 
 		return nil
 	})
@@ -307,29 +288,7 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		log.Panic(err)
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
-
-	//err = bc.db.Update(func(tx *bolt.Tx) error {
-	//	b := tx.Bucket([]byte(blocksBucket))
-	//	err := b.Put(newBlock.Hash, newBlock.Serialize())
-	//	if err != nil {
-	//		log.Panic(err)
-	//	}
-	//
-	//	err = b.Put([]byte("l"), newBlock.Hash)
-	//	if err != nil {
-	//		log.Panic(err)
-	//	}
-	//
-	//	bc.tip = newBlock.Hash
-	//
-	//	return nil
-	//})
-	//if err != nil {
-	//	log.Panic(err)
-	//}
-
-	return newBlock
+	return &Block{timestamp, transactions, lastHash, []byte{}, 0, bits, lastHeight + 1, nil}, nil
 }
 
 // SignTransaction signs inputs of a Transaction
@@ -347,23 +306,15 @@ func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 	tx.Sign(privKey, prevTXs)
 }
 
-// VerifyTransaction verifies transaction input signatures
+// VerifyTransaction checks a transaction against the URPO set
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
-	}
-
-	prevTXs := make(map[string]Transaction)
-
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	err := bc.ValidateTransaction(tx)
+	if err != nil {
+		fmt.Printf("Invalid %s transaction %x: %s\n", tx.Type, tx.ID, err)
+		return false
 	}
 
-	return tx.Verify(prevTXs)
+	return true
 }
 
 func dbExists(dbFile string) bool {
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// newTestBlockchain creates a blockchain in a temporary directory whose
+// genesis block mints a tokoin for the owner, the test gets an empty mempool
+func newTestBlockchain(t *testing.T, owner *wlt.Wallet) *Blockchain {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc := CreateBlockchain(fmt.Sprintf("%s", owner.GetAddress()), "test")
+	URPOSet{bc}.Reindex()
+	prevMempool := mempool
+	mempool = make(map[string]Transaction)
+
+	t.Cleanup(func() {
+		bc.CloseDB()
+		os.Chdir(wd)
+		mempool = prevMempool
+	})
+
+	return bc
+}
+
+func mineTestBlock(t *testing.T, bc *Blockchain, txs ...*Transaction) *Block {
+	block, err := bc.MineBlock(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bc.AddBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// newTestBlock mines a block of the transactions on top of the parent
+func newTestBlock(t *testing.T, bc *Blockchain, parent []byte, txs ...*Transaction) *Block {
+	var block *Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		prev := DeserializeBlock(b.Get(parent))
+		block = &Block{nextTimestamp(b, prev), txs, parent, []byte{}, 0, nextBits(b, prev), prev.Height + 1, nil}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.mine(nil)
+
+	return block
+}
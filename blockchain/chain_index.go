@@ -0,0 +1,136 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// txIndexBucket maps the ID of each transaction on the main chain to the
+// hash of its block followed by its 4-byte position in the block
+const txIndexBucket = "txindex"
+
+// heightIndexBucket maps the 8-byte height of each block on the main chain to its hash
+const heightIndexBucket = "heights"
+
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
+
+func txIndexValue(blockHash []byte, pos int) []byte {
+	value := make([]byte, len(blockHash)+4)
+	copy(value, blockHash)
+	binary.BigEndian.PutUint32(value[len(blockHash):], uint32(pos))
+
+	return value
+}
+
+func parseTxIndexValue(value []byte) ([]byte, int) {
+	n := len(value) - 4
+
+	return value[:n], int(binary.BigEndian.Uint32(value[n:]))
+}
+
+// indexBlock adds the block and its transactions to the indexes
+func indexBlock(tx *bolt.Tx, block *Block) error {
+	err := tx.Bucket([]byte(heightIndexBucket)).Put(heightKey(block.Height), block.Hash)
+	if err != nil {
+		return err
+	}
+
+	idx := tx.Bucket([]byte(txIndexBucket))
+	for pos, t := range block.Transactions {
+		err := idx.Put(t.ID, txIndexValue(block.Hash, pos))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexBlock removes the block and its transactions from the indexes
+func unindexBlock(tx *bolt.Tx, block *Block) error {
+	err := tx.Bucket([]byte(heightIndexBucket)).Delete(heightKey(block.Height))
+	if err != nil {
+		return err
+	}
+
+	idx := tx.Bucket([]byte(txIndexBucket))
+	for _, t := range block.Transactions {
+		err := idx.Delete(t.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexChain rebuilds the indexes from the blocks between the tip and the genesis block
+func indexChain(tx *bolt.Tx) (int, error) {
+	for _, bucketName := range []string{txIndexBucket, heightIndexBucket} {
+		err := tx.DeleteBucket([]byte(bucketName))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return 0, err
+		}
+		_, err = tx.CreateBucket([]byte(bucketName))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	b := tx.Bucket([]byte(blocksBucket))
+	for hash := b.Get([]byte("l")); len(hash) > 0; {
+		block := DeserializeBlock(b.Get(hash))
+		err := indexBlock(tx, block)
+		if err != nil {
+			return 0, err
+		}
+		count += len(block.Transactions)
+		hash = block.PrevBlockHash
+	}
+
+	return count, nil
+}
+
+// ReindexTransactions rebuilds the transaction and height indexes and
+// returns the number of transactions indexed
+func (bc *Blockchain) ReindexTransactions() int {
+	var count int
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		count, err = indexChain(tx)
+
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return count
+}
+
+// GetBlockByHeight finds the block at the height on the main chain
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	var block Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		hash := tx.Bucket([]byte(heightIndexBucket)).Get(heightKey(height))
+		if hash == nil {
+			return fmt.Errorf("there is no block at height %d", height)
+		}
+
+		block = *DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash))
+		return nil
+	})
+
+	return block, err
+}
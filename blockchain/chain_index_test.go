@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestChainIndexes(t *testing.T) {
+	owner := wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	genesis := bc.Iterator().Next()
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	newIssue := func() *Transaction {
+		return NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)
+	}
+
+	issue := newIssue()
+	block := mineTestBlock(t, bc, newIssue(), issue)
+	found, err := bc.FindTransaction(issue.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, issue.ID, found.ID)
+	byHeight, err := bc.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, byHeight.Hash)
+	_, err = bc.FindTransaction(genesis.Transactions[0].ID)
+	assert.Nil(t, err)
+	_, err = bc.FindTransaction([]byte("missing"))
+	assert.NotNil(t, err)
+
+	// a side branch is only indexed once it becomes the main chain
+	side := newIssue()
+	sideBlock := newTestBlock(t, bc, genesis.Hash, side)
+	assert.Nil(t, bc.AddBlock(sideBlock))
+	_, err = bc.FindTransaction(side.ID)
+	assert.NotNil(t, err)
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, sideBlock.Hash, newIssue())))
+	_, err = bc.FindTransaction(side.ID)
+	assert.Nil(t, err)
+	_, err = bc.FindTransaction(issue.ID)
+	assert.NotNil(t, err, "block %x is no longer on the main chain", block.Hash)
+
+	assert.Equal(t, 3, bc.ReindexTransactions())
+	_, err = bc.FindTransaction(side.ID)
+	assert.Nil(t, err)
+
+	// and so is the height of its blocks
+	byHeight, err = bc.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, sideBlock.Hash, byHeight.Hash)
+	byHeight, err = bc.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Hash, byHeight.Hash)
+	_, err = bc.GetBlockByHeight(3)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,214 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/zhuaiballl/Go-Tokoin/config"
+)
+
+// ConsensusEngine decides how the blocks of a node are made and agreed on
+type ConsensusEngine interface {
+	// Start prepares the engine when the node starts
+	Start(bc *Blockchain)
+	// ProposeBlock makes a block of the transactions in the mempool,
+	// if it is the turn of the node, and gets it agreed on
+	ProposeBlock(bc *Blockchain) error
+	// ValidateBlock checks what the engine requires of a block before it is added
+	ValidateBlock(bc *Blockchain, block *Block) error
+	// HandleMessage handles a consensus message of the engine, it returns
+	// false if the command is not one of them
+	HandleMessage(bc *Blockchain, command string, request []byte) bool
+	// Finalize adds an agreed block to the chain
+	Finalize(bc *Blockchain, block *Block) error
+}
+
+// DefaultConsensus is the engine a node uses unless told otherwise
+const DefaultConsensus = "tendermint"
+
+// NewConsensusEngine returns the engine called name: pow, tendermint or dev,
+// Tendermint is run by the validators, which must all have a public key,
+// and key signs the messages of the node
+func NewConsensusEngine(name string, validators *ValidatorSet, key *ecdsa.PrivateKey) (ConsensusEngine, error) {
+	switch name {
+	case "pow":
+		return NewPoWEngine(), nil
+	case "tendermint":
+		err := validators.check()
+		if err != nil {
+			return nil, fmt.Errorf("tendermint cannot run with the validator set: %s", err)
+		}
+		return NewTendermintEngine(validators, key), nil
+	case "dev":
+		return NewDevEngine(), nil
+	}
+
+	return nil, fmt.Errorf("unknown consensus engine %q", name)
+}
+
+// SetConsensus makes the engine validate the blocks added to the chain
+func (bc *Blockchain) SetConsensus(engine ConsensusEngine) {
+	bc.consensus = engine
+}
+
+// powEngine is Nakamoto consensus, the nodes with a mining address mine
+// blocks and the chain with the most work wins
+type powEngine struct{}
+
+// NewPoWEngine returns the proof-of-work engine
+func NewPoWEngine() ConsensusEngine {
+	return powEngine{}
+}
+
+func (powEngine) Start(bc *Blockchain) {}
+
+func (e powEngine) ProposeBlock(bc *Blockchain) error {
+	if len(config.MiningAddress) == 0 {
+		return nil
+	}
+
+	for mempoolSize() > 0 {
+		txs := mempoolBlockTxs(bc)
+		if txs == nil {
+			return nil
+		}
+
+		block, err := bc.MineBlockUntil(txs, startMining())
+		if err != nil {
+			return err
+		}
+		err = e.Finalize(bc, block)
+		if err != nil {
+			return err
+		}
+		fmt.Println("New block is mined!")
+
+		for _, node := range knownNodes {
+			if node != config.NodeAddress {
+				sendInv(node, "block", [][]byte{block.Hash})
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateBlock checks that the hash of the block meets its target and
+// that the target is the one the chain requires after its parent
+func (powEngine) ValidateBlock(bc *Blockchain, block *Block) error {
+	if !NewProofOfWork(block).Validate() {
+		return fmt.Errorf("block %x does not meet its target of %d bits", block.Hash, block.Bits)
+	}
+
+	return bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		parentData := b.Get(block.PrevBlockHash)
+		if parentData == nil {
+			// AddBlock rejects the block
+			return nil
+		}
+
+		if bits := nextBits(b, DeserializeBlock(parentData)); block.Bits != bits {
+			return fmt.Errorf("block %x has %d difficulty bits instead of %d", block.Hash, block.Bits, bits)
+		}
+		return nil
+	})
+}
+
+func (powEngine) HandleMessage(bc *Blockchain, command string, request []byte) bool {
+	return false
+}
+
+func (powEngine) Finalize(bc *Blockchain, block *Block) error {
+	return bc.AddBlock(block)
+}
+
+// devEngine is a single node that commits the blocks it makes at once,
+// without proof-of-work, for local development
+type devEngine struct{}
+
+// NewDevEngine returns the single-node development engine
+func NewDevEngine() ConsensusEngine {
+	return devEngine{}
+}
+
+func (devEngine) Start(bc *Blockchain) {}
+
+func (e devEngine) ProposeBlock(bc *Blockchain) error {
+	txs := mempoolBlockTxs(bc)
+	if txs == nil {
+		return nil
+	}
+
+	block, err := bc.SealBlock(txs)
+	if err != nil {
+		return err
+	}
+
+	return e.Finalize(bc, block)
+}
+
+// ValidateBlock accepts only the blocks sealed by the node itself
+func (devEngine) ValidateBlock(bc *Blockchain, block *Block) error {
+	err := validateSealed(block)
+	if err != nil {
+		return err
+	}
+
+	committingMu.Lock()
+	defer committingMu.Unlock()
+	if !committing[hex.EncodeToString(block.Hash)] {
+		return fmt.Errorf("block %x was not sealed by this node", block.Hash)
+	}
+
+	return nil
+}
+
+func (devEngine) HandleMessage(bc *Blockchain, command string, request []byte) bool {
+	return false
+}
+
+func (devEngine) Finalize(bc *Blockchain, block *Block) error {
+	return commitBlock(bc, block)
+}
+
+// committing holds the hashes of the blocks being committed by commitBlock,
+// the dev engine accepts no other block
+var committing = make(map[string]bool)
+var committingMu sync.Mutex
+
+// validateSealed checks a block made without proof-of-work
+func validateSealed(block *Block) error {
+	if block.Bits != 0 {
+		return fmt.Errorf("block %x has %d difficulty bits, blocks are not mined", block.Hash, block.Bits)
+	}
+	if !NewProofOfWork(block).Validate() {
+		return fmt.Errorf("block %x has a wrong hash", block.Hash)
+	}
+
+	return nil
+}
+
+// commitBlock adds a block the node sealed in dev mode, or that a quorum
+// of validators precommitted, and makes it final
+func commitBlock(bc *Blockchain, block *Block) error {
+	key := hex.EncodeToString(block.Hash)
+	committingMu.Lock()
+	committing[key] = true
+	committingMu.Unlock()
+	defer func() {
+		committingMu.Lock()
+		delete(committing, key)
+		committingMu.Unlock()
+	}()
+
+	err := bc.addBlock(block, true)
+	if err != nil {
+		return err
+	}
+
+	return bc.Finalize(block.Hash)
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestConsensusEngines(t *testing.T) {
+	owner, holder := wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	genesis := bc.Iterator().Next()
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+
+	_, err := NewConsensusEngine("unknown", DefaultValidatorSet(), nil)
+	assert.NotNil(t, err)
+	for _, name := range []string{"pow", "dev"} {
+		_, err = NewConsensusEngine(name, DefaultValidatorSet(), nil)
+		assert.Nil(t, err)
+	}
+	// Tendermint needs validators with keys
+	_, err = NewConsensusEngine("tendermint", DefaultValidatorSet(), nil)
+	assert.NotNil(t, err)
+	keyed := &ValidatorSet{[]Validator{{"localhost:3000", hex.EncodeToString(holder.PublicKey), 1}}}
+	_, err = NewConsensusEngine("tendermint", keyed, nil)
+	assert.Nil(t, err)
+
+	// the dev engine commits a block of the mempool at once, without proof-of-work
+	bc.SetConsensus(NewDevEngine())
+	deposit := Deposit(owner, fmt.Sprintf("%s", holder.GetAddress()), &urpo, Outpoint{genesis.Transactions[0].ID, 0})
+	mempool[hex.EncodeToString(deposit.ID)] = *deposit
+	out := deposit.Vout[0]
+	out.Hold(owner.GetAddress())
+	transfer := spendTokoin(*deposit, OpTransfer, holder, out)
+	mempool[hex.EncodeToString(transfer.ID)] = transfer
+	assert.Nil(t, bc.consensus.ProposeBlock(bc))
+	assert.Equal(t, 1, bc.GetBestHeight())
+	assert.NotContains(t, mempool, hex.EncodeToString(deposit.ID))
+	_, err = bc.FindTransaction(deposit.ID)
+	assert.Nil(t, err)
+
+	// a transaction spending another of the mempool waits for the next block
+	assert.Contains(t, mempool, hex.EncodeToString(transfer.ID))
+	assert.Nil(t, bc.consensus.ProposeBlock(bc))
+	assert.Equal(t, 2, bc.GetBestHeight())
+	assert.NotContains(t, mempool, hex.EncodeToString(transfer.ID))
+	_, err = bc.FindTransaction(transfer.ID)
+	assert.Nil(t, err)
+	sealed, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sealed.Bits)
+
+	// a sealed block relayed by a peer is not accepted by it, nor by Tendermint
+	seal := func(parent *Block, offset int64) *Block {
+		issue := NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)
+		block := &Block{parent.Timestamp + offset, []*Transaction{issue}, parent.Hash, nil, 0, 0, parent.Height + 1, nil}
+		block.Hash = NewProofOfWork(block).hash(0)
+		return block
+	}
+	side := seal(genesis, 1)
+	assert.NotNil(t, bc.AddBlock(side))
+	bc.SetConsensus(NewTendermintEngine(DefaultValidatorSet(), nil))
+	assert.NotNil(t, bc.AddBlock(side))
+	bc.SetConsensus(NewDevEngine())
+
+	// and the block is final, a committed sibling does not replace it
+	assert.NotNil(t, bc.consensus.Finalize(bc, side))
+	assert.Equal(t, sealed.Hash, bc.tip)
+
+	// a committed block replaces a sibling with as much work that is not final
+	first, second := seal(&sealed, 1), seal(&sealed, 2)
+	committing[hex.EncodeToString(first.Hash)] = true
+	assert.Nil(t, bc.AddBlock(first))
+	delete(committing, hex.EncodeToString(first.Hash))
+	assert.Equal(t, first.Hash, bc.tip)
+	assert.Nil(t, bc.consensus.Finalize(bc, second))
+	assert.Equal(t, second.Hash, bc.tip)
+
+	// a mined block is not accepted by it
+	issue := NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)
+	mined, err := bc.MineBlock([]*Transaction{issue})
+	assert.Nil(t, err)
+	assert.NotNil(t, bc.AddBlock(mined))
+
+	// nor is a block without proof-of-work by the proof-of-work engine
+	next, err := bc.SealBlock([]*Transaction{issue})
+	assert.Nil(t, err)
+	bc.SetConsensus(NewPoWEngine())
+	assert.NotNil(t, bc.AddBlock(next))
+	bc.SetConsensus(NewDevEngine())
+	assert.Nil(t, bc.consensus.Finalize(bc, next))
+	assert.Equal(t, next.Hash, bc.tip)
+}
+
+func TestTendermintSync(t *testing.T) {
+	owner, a, b := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	set := &ValidatorSet{[]Validator{
+		{"localhost:3000", hex.EncodeToString(a.PublicKey), 1},
+		{"localhost:3001", hex.EncodeToString(b.PublicKey), 1},
+	}}
+	useValidators(t, set, a)
+	bc := newTestBlockchain(t, owner)
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+
+	// the peer starts from the same genesis block
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(fmt.Sprintf(dbFile, "peer"), 0600)
+	})
+	assert.Nil(t, err)
+	peer := NewBlockchain("peer")
+	t.Cleanup(func() { peer.CloseDB() })
+
+	engine := NewTendermintEngine(set, &a.PrivateKey)
+	bc.SetConsensus(engine)
+	peer.SetConsensus(engine)
+	precommitOf := func(w *wlt.Wallet, addr string, block *Block) precommit {
+		signingKey = &w.PrivateKey
+		p := precommit{addr, block.Height - 1, 0, block.Hash, nil}
+		p.Signature = signMessage("precommit", p.AddrFrom, p.String())
+		return p
+	}
+
+	// the validators commit blocks with the precommits of a quorum
+	var blocks []*Block
+	for i := 0; i < 3; i++ {
+		block, err := bc.SealBlock([]*Transaction{NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)})
+		assert.Nil(t, err)
+		assert.NotNil(t, commitBlock(bc, block))
+		block.Commit = []precommit{precommitOf(a, "localhost:3000", block)}
+		assert.NotNil(t, commitBlock(bc, block))
+		block.Commit = append(block.Commit, precommitOf(b, "localhost:3001", block))
+		assert.Nil(t, commitBlock(bc, block))
+		blocks = append(blocks, block)
+	}
+	assert.Equal(t, 3, bc.GetBestHeight())
+
+	// and a peer that fell behind syncs them, their commits travel with them
+	for _, block := range blocks {
+		stored, err := bc.GetBlock(block.Hash)
+		assert.Nil(t, err)
+		assert.Nil(t, peer.AddBlock(DeserializeBlock(stored.Serialize())))
+	}
+	assert.Equal(t, bc.tip, peer.tip)
+	assert.Equal(t, 3, peer.GetBestHeight())
+
+	// but not a block without a quorum, nor one with a forged or reused commit
+	next, err := peer.SealBlock([]*Transaction{NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)})
+	assert.Nil(t, err)
+	next.Commit = []precommit{precommitOf(a, "localhost:3000", next), precommitOf(a, "localhost:3000", next)}
+	assert.NotNil(t, peer.AddBlock(next))
+	next.Commit = []precommit{precommitOf(a, "localhost:3000", next), precommitOf(a, "localhost:3001", next)}
+	assert.NotNil(t, peer.AddBlock(next))
+	next.Commit = blocks[2].Commit
+	assert.NotNil(t, peer.AddBlock(next))
+	assert.Equal(t, bc.tip, peer.tip)
+}
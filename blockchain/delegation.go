@@ -0,0 +1,221 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// delegationBucket maps the chainstate key of each delegated tokoin to the key of its parent
+const delegationBucket = "delegations"
+
+// childDelegationBucket indexes the delegations by parent, each key is the
+// key of the parent followed by the key of the delegated tokoin. It is
+// built by Reindex and kept in step by putDelegation and deleteDelegation.
+const childDelegationBucket = "childdelegations"
+
+// IsDelegated checks whether the output was delegated by a holder rather than deposited by the owner
+func (out *TXOutput) IsDelegated() bool {
+	return len(out.Delegation) > 0
+}
+
+// delegation formats the holders the tokoin was delegated through
+func (out *TXOutput) delegation() string {
+	if !out.IsDelegated() {
+		return "none"
+	}
+
+	var keys []string
+	for _, key := range out.Delegation {
+		keys = append(keys, fmt.Sprintf("%x", key))
+	}
+
+	return strings.Join(keys, " -> ")
+}
+
+// holderChain formats the delegation chain down to the current holder
+func (out *TXOutput) holderChain() string {
+	if len(out.HolderKey) == 0 {
+		return "none"
+	}
+	if !out.IsDelegated() {
+		return fmt.Sprintf("%x", out.HolderKey)
+	}
+
+	return fmt.Sprintf("%s -> %x", out.delegation(), out.HolderKey)
+}
+
+// NewDelegation derives the tokoin a holder delegates to the holder key,
+// the delegation must end no later than the parent tokoin and a metered
+// parent lends one of its uses
+func (out *TXOutput) NewDelegation(holderKey []byte, expiryHeight int, expiryTime int64) (*TXOutput, error) {
+	if len(out.HolderKey) == 0 {
+		return nil, errors.New("only a held tokoin can be delegated")
+	}
+	if expiryHeight <= 0 && expiryTime <= 0 {
+		return nil, errors.New("a delegation needs an expiry height or time")
+	}
+	if out.ExpiryHeight > 0 && (expiryHeight <= 0 || expiryHeight > out.ExpiryHeight) {
+		return nil, fmt.Errorf("a delegation cannot outlive the tokoin, which expires at height %d", out.ExpiryHeight)
+	}
+	if out.ExpiryTime > 0 && (expiryTime <= 0 || expiryTime > out.ExpiryTime) {
+		return nil, fmt.Errorf("a delegation cannot outlive the tokoin, which expires at time %d", out.ExpiryTime)
+	}
+	if out.Metered && out.RemainingUses <= 0 {
+		return nil, errors.New("tokoin has no uses left")
+	}
+
+	delegated := *out
+	delegated.HolderKey = holderKey
+	delegated.ExpiryHeight, delegated.ExpiryTime = expiryHeight, expiryTime
+	delegated.Delegation = append(append([][]byte{}, out.Delegation...), out.HolderKey)
+	if out.Metered {
+		delegated.RemainingUses = 1
+	}
+
+	return &delegated, nil
+}
+
+// checkDelegation checks that a delegate transaction keeps the parent
+// tokoin as its first output and delegates it with the second
+func (tx *Transaction) checkDelegation(prev TXOutput) error {
+	if len(tx.Vin) != 1 || len(tx.Vout) != 2 {
+		return errors.New("delegate must spend exactly one tokoin and create two")
+	}
+
+	parent := prev
+	if parent.Metered {
+		parent.RemainingUses--
+	}
+	if !bytes.Equal(parent.Serialize(), tx.Vout[0].Serialize()) {
+		return errors.New("delegate must keep the parent tokoin unchanged")
+	}
+
+	out := tx.Vout[1]
+	if len(tx.Payload.Holder) == 0 || !out.IsHeldWithKey(tx.Payload.Holder) {
+		return errors.New("delegate must set the holder named in its payload")
+	}
+	expected, err := prev.NewDelegation(tx.Payload.Holder, out.ExpiryHeight, out.ExpiryTime)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expected.Serialize(), out.Serialize()) {
+		return errors.New("delegate can only change the holder and the expiry of the delegated tokoin")
+	}
+
+	return nil
+}
+
+// moveDelegations re-parents the delegations of a tokoin that was delegated again
+func moveDelegations(j *undoJournal, from, to Outpoint) error {
+	fromKey, toKey := from.Key(), to.Key()
+	d := j.bucket(delegationBucket)
+
+	for _, child := range childDelegations(d, fromKey) {
+		err := j.put(delegationBucket, child, toKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the tokoin may itself be a delegation
+	if parent := d.Get(fromKey); parent != nil {
+		err := j.put(delegationBucket, toKey, append([]byte{}, parent...))
+		if err != nil {
+			return err
+		}
+		return j.delete(delegationBucket, fromKey)
+	}
+
+	return nil
+}
+
+// revokeDelegations removes every tokoin delegated from the spent tokoin,
+// recursively, from the chainstate
+func revokeDelegations(j *undoJournal, spent Outpoint) error {
+	return revokeDelegationKey(j, spent.Key())
+}
+
+func revokeDelegationKey(j *undoJournal, key []byte) error {
+	for _, child := range childDelegations(j.bucket(delegationBucket), key) {
+		err := j.delete(urpoBucket, child)
+		if err != nil {
+			return err
+		}
+		err = revokeDelegationKey(j, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.bucket(delegationBucket).Get(key) == nil {
+		return nil
+	}
+	return j.delete(delegationBucket, key)
+}
+
+// putDelegation records the parent of the delegated tokoin and indexes it
+func putDelegation(d *bolt.Bucket, child, parent []byte) error {
+	err := deleteDelegation(d, child)
+	if err != nil {
+		return err
+	}
+	err = d.Put(child, parent)
+	if err != nil {
+		return err
+	}
+
+	if idx := d.Tx().Bucket([]byte(childDelegationBucket)); idx != nil {
+		return idx.Put(childDelegationKey(parent, child), []byte{})
+	}
+	return nil
+}
+
+// deleteDelegation removes the delegated tokoin from the delegations and from the index
+func deleteDelegation(d *bolt.Bucket, child []byte) error {
+	parent := d.Get(child)
+	if parent == nil {
+		return nil
+	}
+
+	if idx := d.Tx().Bucket([]byte(childDelegationBucket)); idx != nil {
+		err := idx.Delete(childDelegationKey(parent, child))
+		if err != nil {
+			return err
+		}
+	}
+	return d.Delete(child)
+}
+
+func childDelegationKey(parent, child []byte) []byte {
+	key := make([]byte, 0, len(parent)+len(child))
+
+	return append(append(key, parent...), child...)
+}
+
+// childDelegations returns the keys of the tokoins delegated from the
+// parent, the delegations are scanned when the index is missing
+func childDelegations(d *bolt.Bucket, parent []byte) [][]byte {
+	var children [][]byte
+
+	idx := d.Tx().Bucket([]byte(childDelegationBucket))
+	if idx == nil {
+		c := d.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if bytes.Equal(v, parent) {
+				children = append(children, append([]byte{}, k...))
+			}
+		}
+		return children
+	}
+
+	c := idx.Cursor()
+	for k, _ := c.Seek(parent); k != nil && bytes.HasPrefix(k, parent); k, _ = c.Next() {
+		children = append(children, append([]byte{}, k[len(parent):]...))
+	}
+
+	return children
+}
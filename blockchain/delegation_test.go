@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestDelegation(t *testing.T) {
+	owner, holder, contractor, other := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	genesis := bc.Iterator().Next().Transactions[0]
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	contractorAddress := fmt.Sprintf("%s", contractor.GetAddress())
+	otherAddress := fmt.Sprintf("%s", other.GetAddress())
+	ctx := RedeemContext{Temperature: 37}
+
+	deposit := Deposit(owner, fmt.Sprintf("%s", holder.GetAddress()), &urpo, Outpoint{genesis.ID, 0})
+	mineTestBlock(t, bc, deposit)
+
+	// only the holder can delegate, and only for a limited time
+	assert.Panics(t, func() { Delegate(*owner, contractorAddress, &urpo, Outpoint{deposit.ID, 0}, "10", "") })
+	assert.Panics(t, func() { Delegate(*holder, contractorAddress, &urpo, Outpoint{deposit.ID, 0}, "", "") })
+	delegate := Delegate(*holder, contractorAddress, &urpo, Outpoint{deposit.ID, 0}, "10", "")
+	assert.Nil(t, bc.ValidateTransaction(delegate))
+	tampered := *delegate
+	tampered.Vout = []TXOutput{delegate.Vout[0], delegate.Vout[1]}
+	tampered.Vout[1].Temperature = 5
+	assert.NotNil(t, tampered.CheckOperation(map[string]Transaction{fmt.Sprintf("%x", deposit.ID): *deposit}))
+	mineTestBlock(t, bc, delegate)
+
+	// the contractor redeems on behalf of the holder, which uses up the delegation
+	redeem := RedeemTokoin(*contractor, ownerAddress, &urpo, Outpoint{delegate.ID, 1}, &ctx)
+	assert.Nil(t, bc.ValidateTransaction(redeem))
+	assert.Empty(t, redeem.Vout)
+
+	// but cannot pass it on for longer than it was lent, nor transfer it
+	assert.Panics(t, func() { Delegate(*contractor, otherAddress, &urpo, Outpoint{delegate.ID, 1}, "11", "") })
+	transfer := Transfer(*contractor, otherAddress, &urpo, Outpoint{delegate.ID, 1})
+	assert.NotNil(t, bc.ValidateTransaction(transfer))
+	sub := Delegate(*contractor, otherAddress, &urpo, Outpoint{delegate.ID, 1}, "5", "")
+	assert.Nil(t, bc.ValidateTransaction(sub))
+	mineTestBlock(t, bc, sub)
+	assert.Equal(t, [][]byte{deposit.Vout[0].HolderKey, wlt.HashPubKey(contractor.PublicKey)}, sub.Vout[1].Delegation)
+
+	// delegating the parent again keeps the existing delegations
+	again := Delegate(*holder, contractorAddress, &urpo, Outpoint{delegate.ID, 0}, "10", "")
+	mineTestBlock(t, bc, again)
+	assert.True(t, urpo.IsUnspent(Outpoint{sub.ID, 1}))
+	children := func(parent Outpoint) [][]byte {
+		var keys [][]byte
+		err := bc.db.View(func(tx *bolt.Tx) error {
+			keys = childDelegations(tx.Bucket([]byte(delegationBucket)), parent.Key())
+			return nil
+		})
+		assert.Nil(t, err)
+		return keys
+	}
+	delegated := [][]byte{Outpoint{sub.ID, 0}.Key(), Outpoint{again.ID, 1}.Key()}
+	assert.ElementsMatch(t, delegated, children(Outpoint{again.ID, 0}))
+	assert.Empty(t, children(Outpoint{delegate.ID, 0}))
+
+	// the delegations are scanned without the index, as before it existed
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(childDelegationBucket))
+	})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, delegated, children(Outpoint{again.ID, 0}))
+
+	// redeeming the parent revokes the whole delegation chain
+	redeem = RedeemTokoin(*other, ownerAddress, &urpo, Outpoint{sub.ID, 1}, &ctx)
+	assert.Nil(t, bc.ValidateTransaction(redeem))
+	mineTestBlock(t, bc, RedeemTokoin(*holder, ownerAddress, &urpo, Outpoint{again.ID, 0}, &ctx))
+	assert.NotNil(t, bc.ValidateTransaction(redeem))
+	for _, outpoint := range []Outpoint{{again.ID, 1}, {sub.ID, 0}, {sub.ID, 1}} {
+		assert.False(t, urpo.IsUnspent(outpoint), outpoint.String())
+	}
+
+	urpo.Reindex()
+	for _, outpoint := range []Outpoint{{again.ID, 1}, {sub.ID, 0}, {sub.ID, 1}} {
+		assert.False(t, urpo.IsUnspent(outpoint), outpoint.String())
+	}
+	assert.Equal(t, 1, len(urpo.FindURPO(wlt.HashPubKey(owner.PublicKey))))
+}
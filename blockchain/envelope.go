@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// TxEnvelope is the portable form of a transaction that is signed away from
+// the node, it carries the transactions spent by the inputs so that the
+// signer needs neither a copy of the blockchain nor network access
+type TxEnvelope struct {
+	Transaction Transaction
+	PrevTXs     []Transaction
+}
+
+// NewTxEnvelope wraps the transaction with the transactions it spends
+func NewTxEnvelope(tx *Transaction, bc *Blockchain) *TxEnvelope {
+	envelope := TxEnvelope{Transaction: *tx}
+
+	if tx.IsCoinbase() {
+		return &envelope
+	}
+	for txID := range inputTxids(tx) {
+		txid, err := hex.DecodeString(txID)
+		if err != nil {
+			log.Panic(err)
+		}
+		prevTX, err := bc.FindTransaction(txid)
+		if err != nil {
+			log.Panic(err)
+		}
+		envelope.PrevTXs = append(envelope.PrevTXs, prevTX)
+	}
+
+	return &envelope
+}
+
+func inputTxids(tx *Transaction) map[string]bool {
+	txids := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		txids[hex.EncodeToString(vin.Txid)] = true
+	}
+
+	return txids
+}
+
+func (e *TxEnvelope) prevTXs() map[string]Transaction {
+	prevTXs := make(map[string]Transaction)
+	for _, prevTX := range e.PrevTXs {
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+
+	return prevTXs
+}
+
+// check makes sure the envelope carries every spent output and that the
+// transactions match their IDs
+func (e *TxEnvelope) check() error {
+	if !bytes.Equal(e.Transaction.ID, e.Transaction.Hash()) {
+		return fmt.Errorf("transaction %x does not match its ID", e.Transaction.ID)
+	}
+	if e.Transaction.IsCoinbase() {
+		return nil
+	}
+	for _, prevTX := range e.PrevTXs {
+		if !bytes.Equal(prevTX.ID, prevTX.Hash()) {
+			return fmt.Errorf("spent transaction %x does not match its ID", prevTX.ID)
+		}
+	}
+
+	prevTXs := e.prevTXs()
+	for _, vin := range e.Transaction.Vin {
+		prevTX, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok {
+			return fmt.Errorf("spent transaction %x is missing", vin.Txid)
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return fmt.Errorf("spent tokoin %s does not exist", vin.Outpoint())
+		}
+	}
+
+	return nil
+}
+
+// SpentOutputs returns the outputs spent by the inputs, in input order
+func (e *TxEnvelope) SpentOutputs() []TXOutput {
+	var outputs []TXOutput
+
+	prevTXs := e.prevTXs()
+	for _, vin := range e.Transaction.Vin {
+		outputs = append(outputs, prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout])
+	}
+
+	return outputs
+}
+
+// IsSigner checks whether the key hash is expected to sign the transaction
+func (e *TxEnvelope) IsSigner(pubKeyHash []byte) bool {
+	if e.Transaction.IsCoinbase() {
+		return false
+	}
+	if e.Transaction.signsPayload() {
+		return bytes.Equal(e.Transaction.Payload.Owner, pubKeyHash)
+	}
+
+	role := e.Transaction.Type.SignerRole()
+	for _, out := range e.SpentOutputs() {
+		if usesWitnesses(role, &out) {
+			if !out.IsOwnedBy(pubKeyHash) {
+				return false
+			}
+		} else if key := out.KeyForRole(role); len(key) == 0 || !bytes.Equal(key, pubKeyHash) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Sign adds the signature of the key to the transaction
+func (e *TxEnvelope) Sign(privKey ecdsa.PrivateKey) error {
+	if !e.IsSigner(wlt.HashPubKey(publicKeyBytes(privKey))) {
+		return fmt.Errorf("key is not a signer of transaction %x", e.Transaction.ID)
+	}
+
+	e.Transaction.Sign(privKey, e.prevTXs())
+	return nil
+}
+
+// MissingSignatures returns how many more signatures the transaction needs
+func (e *TxEnvelope) MissingSignatures() int {
+	return e.Transaction.MissingSignatures(e.prevTXs())
+}
+
+// Verify checks that every input is signed, and signed by the right party
+func (e *TxEnvelope) Verify() bool {
+	prevTXs := e.prevTXs()
+
+	return e.Transaction.Verify(prevTXs) && e.Transaction.VerifySigners(prevTXs)
+}
+
+// Combine merges the signatures of another copy of the same transaction
+func (e *TxEnvelope) Combine(other *TxEnvelope) error {
+	return e.Transaction.Combine(&other.Transaction)
+}
+
+// Save writes the envelope to a JSON file
+func (e *TxEnvelope) Save(path string) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadTxEnvelope reads an envelope written by Save
+func LoadTxEnvelope(path string) (*TxEnvelope, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope TxEnvelope
+	err = json.Unmarshal(data, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	err = envelope.check()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return &envelope, nil
+}
@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestOfflineSigning(t *testing.T) {
+	owner, holder, stranger := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	issue := NewCoinbaseTX(fmt.Sprintf("%s", owner.GetAddress()), "", 0, nil, Coordinate{}, 37, 0)
+	mineTestBlock(t, bc, issue)
+
+	// the node builds the deposit knowing only the owner address
+	path := filepath.Join(t.TempDir(), "deposit.tx")
+	deposit := BuildDeposit(wlt.HashPubKey(owner.PublicKey), fmt.Sprintf("%s", holder.GetAddress()), &urpo, Outpoint{issue.ID, 0})
+	assert.Nil(t, NewTxEnvelope(deposit, bc).Save(path))
+
+	// the signer only has the file and the key
+	envelope, err := LoadTxEnvelope(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, envelope.MissingSignatures())
+	assert.False(t, envelope.IsSigner(wlt.HashPubKey(stranger.PublicKey)))
+	assert.NotNil(t, envelope.Sign(stranger.PrivateKey))
+	assert.Nil(t, envelope.Sign(owner.PrivateKey))
+	assert.Equal(t, 0, envelope.MissingSignatures())
+	assert.True(t, envelope.Verify())
+	assert.Nil(t, envelope.Save(path))
+
+	signed, err := LoadTxEnvelope(path)
+	assert.Nil(t, err)
+	assert.Equal(t, deposit.ID, signed.Transaction.ID)
+	assert.Nil(t, bc.ValidateTransaction(&signed.Transaction))
+
+	// a tampered file fails verification, and is not loaded
+	signed.Transaction.Vout[0].HolderKey = wlt.HashPubKey(stranger.PublicKey)
+	assert.False(t, signed.Verify())
+	assert.Nil(t, signed.Save(path))
+	_, err = LoadTxEnvelope(path)
+	assert.NotNil(t, err)
+
+	// nor is one that forges the spent tokoin
+	forged := NewTxEnvelope(deposit, bc)
+	forged.PrevTXs[0].Vout[0].PubKeyHash = wlt.HashPubKey(stranger.PublicKey)
+	assert.Nil(t, forged.Save(path))
+	_, err = LoadTxEnvelope(path)
+	assert.NotNil(t, err)
+
+	_, err = LoadTxEnvelope(filepath.Join(t.TempDir(), "missing.tx"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,367 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// chainWorkBucket maps the hash of each stored block to the total work of
+// the chain it ends, the best chain is the one with the most work that
+// keeps the finalized block
+const chainWorkBucket = "chainwork"
+
+// invalidBlocksBucket holds the hashes of the stored blocks of side branches
+// that turned out invalid when a reorganization connected them, and of the
+// blocks stored on top of them, no branch containing them becomes the tip
+const invalidBlocksBucket = "invalidblocks"
+
+// chainMu serializes the changes to the chain, the blocks of the peers
+// arrive while the node makes its own
+var chainMu sync.Mutex
+
+// finalizedKey holds the hash of the last block committed by the validators,
+// the chain is never reorganized past it
+var finalizedKey = []byte("f")
+
+// chainWork returns the total work of the chain ending with the block,
+// computing and storing it for blocks saved before the work was recorded
+func chainWork(tx *bolt.Tx, hash []byte) (*big.Int, error) {
+	w, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+	if err != nil {
+		return nil, err
+	}
+	b := tx.Bucket([]byte(blocksBucket))
+
+	var missing []*Block
+	work := new(big.Int)
+	for len(hash) > 0 {
+		if v := w.Get(hash); v != nil {
+			work.SetBytes(v)
+			break
+		}
+		blockData := b.Get(hash)
+		if blockData == nil {
+			return nil, fmt.Errorf("block %x is not found", hash)
+		}
+		block := DeserializeBlock(blockData)
+		missing = append(missing, block)
+		hash = block.PrevBlockHash
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		work.Add(work, NewProofOfWork(missing[i]).Work())
+		err := w.Put(missing[i].Hash, work.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return work, nil
+}
+
+// ancestorAt returns the hash of the ancestor of the block at the height
+func ancestorAt(b *bolt.Bucket, block *Block, height int) []byte {
+	for block.Height > height {
+		block = DeserializeBlock(b.Get(block.PrevBlockHash))
+	}
+
+	return block.Hash
+}
+
+// keepsFinalized checks whether the chain ending with the block contains the finalized block
+func keepsFinalized(tx *bolt.Tx, block *Block) bool {
+	b := tx.Bucket([]byte(blocksBucket))
+	finalized := b.Get(finalizedKey)
+	if finalized == nil {
+		return true
+	}
+
+	f := DeserializeBlock(b.Get(finalized))
+	if block.Height < f.Height {
+		return false
+	}
+
+	return bytes.Equal(ancestorAt(b, block, f.Height), f.Hash)
+}
+
+// Finalize marks a block of the main chain as committed, so that no
+// competing branch can replace it, as Tendermint does for every block
+func (bc *Blockchain) Finalize(hash []byte) error {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	block, err := bc.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+	onChain, err := bc.GetBlockByHeight(block.Height)
+	if err != nil || !bytes.Equal(onChain.Hash, hash) {
+		return fmt.Errorf("block %x is not on the main chain", hash)
+	}
+
+	// a finalized block is never disconnected, nor are its ancestors
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(blocksBucket)).Put(finalizedKey, hash)
+		if err != nil {
+			return err
+		}
+
+		return pruneUndo(tx, hash)
+	})
+}
+
+// AddBlock saves the block and makes it the tip when its chain has more work
+// than the main chain and keeps the finalized block, reorganizing the chain
+// when it is on another branch. A block extending the tip is rejected if any
+// of its transactions is invalid, a block on another branch if any of them
+// is malformed, and a block whose parent is unknown, whose time is out of
+// range or that the consensus engine does not accept is rejected as well.
+func (bc *Blockchain) AddBlock(block *Block) error {
+	return bc.addBlock(block, false)
+}
+
+// addBlock is AddBlock, a block the validators agreed on becomes the tip
+// even if its chain has no more work than the main chain
+func (bc *Blockchain) addBlock(block *Block, agreed bool) error {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	err := bc.consensus.ValidateBlock(bc, block)
+	if err != nil {
+		return err
+	}
+
+	// a block on a side branch is validated against its chain state once
+	// a reorganization connects it
+	extendsTip := bytes.Equal(bc.tip, block.PrevBlockHash)
+	if extendsTip {
+		err = bc.ValidateBlock(block)
+	} else {
+		err = checkBlock(block)
+	}
+	if err != nil {
+		return err
+	}
+
+	best := false
+	err = bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		if b.Get(block.Hash) != nil {
+			tip := DeserializeBlock(b.Get(bc.tip))
+			onChain := block.Height <= tip.Height && bytes.Equal(ancestorAt(b, tip, block.Height), block.Hash)
+			best = agreed && !onChain && keepsFinalized(tx, block)
+			return nil
+		}
+
+		parentData := b.Get(block.PrevBlockHash)
+		if parentData == nil {
+			return fmt.Errorf("block %x has an unknown parent %x", block.Hash, block.PrevBlockHash)
+		}
+		if isInvalid(tx, block.PrevBlockHash) {
+			return fmt.Errorf("block %x has an invalid parent %x", block.Hash, block.PrevBlockHash)
+		}
+		parent := DeserializeBlock(parentData)
+		if block.Height != parent.Height+1 {
+			return fmt.Errorf("block %x has height %d but its parent has height %d", block.Hash, block.Height, parent.Height)
+		}
+		err := checkTimestamp(b, parent, block)
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(block.Hash, block.Serialize())
+		if err != nil {
+			log.Panic(err)
+		}
+
+		work, err := chainWork(tx, block.Hash)
+		if err != nil {
+			return err
+		}
+		tipWork, err := chainWork(tx, bc.tip)
+		if err != nil {
+			return err
+		}
+		best = (agreed || work.Cmp(tipWork) > 0) && keepsFinalized(tx, block)
+
+		return nil
+	})
+	if err != nil || !best {
+		return err
+	}
+
+	if extendsTip {
+		bc.connectTip(block)
+		updateMempool(nil, []*Block{block})
+		return nil
+	}
+
+	return bc.reorganize(block)
+}
+
+// reorganize moves the tip to the block on another branch, disconnecting
+// the blocks of the main chain down to the fork and connecting the blocks
+// of the branch. The main chain is restored if a block of the branch is
+// invalid or a block of the main chain cannot be disconnected, an invalid
+// block and the blocks of the branch above it are marked invalid.
+func (bc *Blockchain) reorganize(newTip *Block) error {
+	var oldBranch, newBranch []*Block
+	invalid := -1
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		oldTip := DeserializeBlock(b.Get(bc.tip))
+
+		for block := newTip; ; {
+			if block.Height <= oldTip.Height && bytes.Equal(ancestorAt(b, oldTip, block.Height), block.Hash) {
+				break
+			}
+			if invalid < 0 && isInvalid(tx, block.Hash) {
+				invalid = len(newBranch)
+			}
+			newBranch = append(newBranch, block)
+			block = DeserializeBlock(b.Get(block.PrevBlockHash))
+		}
+
+		fork := newBranch[len(newBranch)-1].PrevBlockHash
+		for block := oldTip; !bytes.Equal(block.Hash, fork); {
+			oldBranch = append(oldBranch, block)
+			block = DeserializeBlock(b.Get(block.PrevBlockHash))
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	if invalid >= 0 {
+		bc.markInvalid(newBranch[:invalid])
+		return fmt.Errorf("block %x descends from the invalid block %x", newTip.Hash, newBranch[invalid].Hash)
+	}
+
+	fmt.Printf("Reorganizing: disconnecting %d blocks and connecting %d blocks\n", len(oldBranch), len(newBranch))
+	for k := range oldBranch {
+		err := bc.disconnectTip()
+		if err != nil {
+			// the tip is left as it was, reconnect the blocks disconnected so far
+			for j := k - 1; j >= 0; j-- {
+				bc.connectTip(oldBranch[j])
+			}
+			return fmt.Errorf("cannot reorganize onto block %x: %s", newTip.Hash, err)
+		}
+	}
+
+	for i := len(newBranch) - 1; i >= 0; i-- {
+		err := bc.ValidateBlock(newBranch[i])
+		if err == nil {
+			bc.connectTip(newBranch[i])
+			continue
+		}
+
+		for j := len(newBranch) - 1; j > i; j-- {
+			err := bc.disconnectTip()
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+		for j := len(oldBranch) - 1; j >= 0; j-- {
+			bc.connectTip(oldBranch[j])
+		}
+		bc.markInvalid(newBranch[:i+1])
+		return fmt.Errorf("invalid block %x on the new branch: %s", newBranch[i].Hash, err)
+	}
+
+	updateMempool(oldBranch, newBranch)
+	return nil
+}
+
+// isInvalid checks whether the block was marked invalid
+func isInvalid(tx *bolt.Tx, hash []byte) bool {
+	b := tx.Bucket([]byte(invalidBlocksBucket))
+
+	return b != nil && b.Get(hash) != nil
+}
+
+// markInvalid marks the blocks invalid
+func (bc *Blockchain) markInvalid(blocks []*Block) {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(invalidBlocksBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, block := range blocks {
+			err = b.Put(block.Hash, []byte{1})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// currentTip returns the hash of the tip
+func (bc *Blockchain) currentTip() []byte {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	return bc.tip
+}
+
+// connectTip makes the block, a child of the tip, the new tip
+func (bc *Blockchain) connectTip(block *Block) {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(blocksBucket)).Put([]byte("l"), block.Hash)
+		if err != nil {
+			return err
+		}
+
+		return indexBlock(tx, block)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.tip = block.Hash
+
+	URPOSet{bc}.Update(block)
+}
+
+// disconnectTip makes the parent of the tip the new tip
+func (bc *Blockchain) disconnectTip() error {
+	block, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		return err
+	}
+	if len(block.PrevBlockHash) == 0 {
+		return errors.New("the genesis block cannot be disconnected")
+	}
+
+	err = URPOSet{bc}.Rollback(&block)
+	if err != nil {
+		return err
+	}
+
+	err = bc.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(blocksBucket)).Put([]byte("l"), block.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+
+		return unindexBlock(tx, &block)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.tip = block.PrevBlockHash
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestReorg(t *testing.T) {
+	owner, holder := wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	genesis := bc.Iterator().Next()
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	newIssue := func() *Transaction {
+		return NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)
+	}
+	initial := dumpChainstate(t, bc)
+
+	deposit := Deposit(owner, fmt.Sprintf("%s", holder.GetAddress()), &urpo, Outpoint{genesis.Transactions[0].ID, 0})
+	mineTestBlock(t, bc, deposit)
+	depositID := hex.EncodeToString(deposit.ID)
+
+	// a branch with as much work does not replace the main chain
+	side := newTestBlock(t, bc, genesis.Hash, newIssue())
+	assert.Nil(t, bc.AddBlock(side))
+	assert.Equal(t, 1, bc.GetBestHeight())
+	_, err := bc.FindTransaction(deposit.ID)
+	assert.Nil(t, err)
+
+	// one with more work does, the deposit is undone and back in the mempool
+	sideTip := newTestBlock(t, bc, side.Hash, newIssue())
+	assert.Nil(t, bc.AddBlock(sideTip))
+	assert.Equal(t, sideTip.Hash, bc.tip)
+	_, err = bc.FindTransaction(deposit.ID)
+	assert.NotNil(t, err)
+	assert.Contains(t, mempool, depositID)
+	assert.NotEqual(t, initial, dumpChainstate(t, bc))
+	reorged := dumpChainstate(t, bc)
+	urpo.Reindex()
+	assert.Equal(t, reorged, dumpChainstate(t, bc))
+	assert.Nil(t, bc.ValidateTransaction(deposit))
+
+	// a branch with an invalid block leaves the main chain as it was
+	bad := *deposit
+	bad.Vin = []TXInput{{[]byte("missing"), 0, nil, nil, nil}}
+	malformed := newTestBlock(t, bc, side.Hash, newIssue(), &bad)
+	assert.NotNil(t, bc.AddBlock(malformed))
+	bad.ID = bad.Hash()
+	invalid := newTestBlock(t, bc, side.Hash, newIssue(), &bad)
+	assert.Nil(t, bc.AddBlock(invalid))
+	assert.NotNil(t, bc.AddBlock(newTestBlock(t, bc, invalid.Hash, newIssue())))
+	assert.Equal(t, sideTip.Hash, bc.tip)
+
+	// and it is marked invalid, the blocks on top of it are rejected at once
+	err = bc.db.View(func(tx *bolt.Tx) error {
+		assert.True(t, isInvalid(tx, invalid.Hash))
+		assert.False(t, isInvalid(tx, side.Hash))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, bc.AddBlock(newTestBlock(t, bc, invalid.Hash, newIssue())))
+	assert.Equal(t, sideTip.Hash, bc.tip)
+	assert.Equal(t, reorged, dumpChainstate(t, bc))
+	byHeight, err := bc.GetBlockByHeight(2)
+	assert.Nil(t, err)
+	assert.Equal(t, sideTip.Hash, byHeight.Hash)
+
+	// nor can a branch replace a finalized block
+	assert.NotNil(t, bc.Finalize(invalid.Hash))
+	assert.Nil(t, bc.Finalize(sideTip.Hash))
+	fork := newTestBlock(t, bc, side.Hash, newIssue())
+	assert.Nil(t, bc.AddBlock(fork))
+	assert.Nil(t, bc.AddBlock(newTestBlock(t, bc, fork.Hash, newIssue())))
+	assert.Equal(t, sideTip.Hash, bc.tip)
+	next := newTestBlock(t, bc, sideTip.Hash, newIssue())
+	assert.Nil(t, bc.AddBlock(next))
+	assert.Equal(t, next.Hash, bc.tip)
+
+	// a branch that needs a block without a journal to be disconnected
+	// leaves the main chain as it was
+	after := newTestBlock(t, bc, next.Hash, newIssue())
+	assert.Nil(t, bc.AddBlock(after))
+	state := dumpChainstate(t, bc)
+	err = bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(undoBucket)).Delete(next.Hash)
+	})
+	assert.Nil(t, err)
+	branch := sideTip.Hash
+	for i := 0; i < 2; i++ {
+		block := newTestBlock(t, bc, branch, newIssue())
+		assert.Nil(t, bc.AddBlock(block))
+		branch = block.Hash
+	}
+	assert.NotNil(t, bc.AddBlock(newTestBlock(t, bc, branch, newIssue())))
+	assert.Equal(t, after.Hash, bc.tip)
+	assert.Equal(t, state, dumpChainstate(t, bc))
+	byHeight, err = bc.GetBlockByHeight(4)
+	assert.Nil(t, err)
+	assert.Equal(t, after.Hash, byHeight.Hash)
+
+	// and a block whose parent is unknown is rejected
+	assert.NotNil(t, bc.AddBlock(NewBlock([]*Transaction{newIssue()}, []byte("missing"), 4, initialBits)))
+}
+
+func TestConcurrentBlocks(t *testing.T) {
+	owner := wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	genesis := bc.Iterator().Next()
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+
+	// competing branches arriving at once leave a chain the URPO set matches
+	var tips []*Block
+	for i := 0; i < 4; i++ {
+		first := newTestBlock(t, bc, genesis.Hash, NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0))
+		second := &Block{first.Timestamp + 1, []*Transaction{NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)}, first.Hash, []byte{}, 0, first.Bits, 2, nil}
+		second.mine(nil)
+		tips = append(tips, first, second)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < len(tips); i += 2 {
+		wg.Add(1)
+		go func(first, second *Block) {
+			defer wg.Done()
+			assert.Nil(t, bc.AddBlock(first))
+			assert.Nil(t, bc.AddBlock(second))
+		}(tips[i], tips[i+1])
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, bc.GetBestHeight())
+	state := dumpChainstate(t, bc)
+	URPOSet{bc}.Reindex()
+	assert.Equal(t, state, dumpChainstate(t, bc))
+}
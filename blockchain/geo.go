@@ -0,0 +1,210 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// coordinateScale is the number of fixed-point units per degree
+const coordinateScale = 1000000
+
+// earthRadius is the mean radius of the Earth in metres
+const earthRadius = 6371008.8
+
+// Coordinate is a WGS84 position with latitude and longitude in micro-degrees
+type Coordinate struct {
+	Lat int64
+	Lon int64
+}
+
+// ParseCoordinate parses a "lat,lon" pair given in decimal degrees
+func ParseCoordinate(spec string) (Coordinate, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != 2 {
+		return Coordinate{}, fmt.Errorf("invalid coordinate %q, expected lat,lon", spec)
+	}
+
+	return parseLatLon(parts[0], parts[1])
+}
+
+func parseLatLon(latSpec, lonSpec string) (Coordinate, error) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latSpec), 64)
+	if err != nil {
+		return Coordinate{}, fmt.Errorf("invalid latitude %q", latSpec)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonSpec), 64)
+	if err != nil {
+		return Coordinate{}, fmt.Errorf("invalid longitude %q", lonSpec)
+	}
+	if lat < -90 || lat > 90 {
+		return Coordinate{}, fmt.Errorf("latitude %v is out of range", lat)
+	}
+	if lon < -180 || lon > 180 {
+		return Coordinate{}, fmt.Errorf("longitude %v is out of range", lon)
+	}
+
+	return Coordinate{int64(math.Round(lat * coordinateScale)), int64(math.Round(lon * coordinateScale))}, nil
+}
+
+func (c Coordinate) String() string {
+	return fmt.Sprintf("%.6f,%.6f", c.latDegrees(), c.lonDegrees())
+}
+
+func (c Coordinate) latDegrees() float64 {
+	return float64(c.Lat) / coordinateScale
+}
+
+func (c Coordinate) lonDegrees() float64 {
+	return float64(c.Lon) / coordinateScale
+}
+
+// DistanceTo returns the great-circle distance to another coordinate in metres
+func (c Coordinate) DistanceTo(other Coordinate) float64 {
+	lat1 := c.latDegrees() * math.Pi / 180
+	lat2 := other.latDegrees() * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (other.lonDegrees() - c.lonDegrees()) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// project maps a coordinate to planar metres relative to the origin,
+// which is accurate enough for geofences spanning a few kilometres
+func (c Coordinate) project(origin Coordinate) (float64, float64) {
+	lat0 := origin.latDegrees() * math.Pi / 180
+	dLon := c.lonDegrees() - origin.lonDegrees()
+	if dLon > 180 {
+		dLon -= 360
+	} else if dLon < -180 {
+		dLon += 360
+	}
+
+	x := dLon * math.Pi / 180 * math.Cos(lat0) * earthRadius
+	y := (c.latDegrees() - origin.latDegrees()) * math.Pi / 180 * earthRadius
+
+	return x, y
+}
+
+// Geofence restricts redemption to a circle (Center and Radius in metres)
+// or to the area enclosed by Polygon
+type Geofence struct {
+	Center  Coordinate
+	Radius  int
+	Polygon []Coordinate
+}
+
+// ParseGeofence parses "lat,lon,radius" as a circle or
+// "lat,lon;lat,lon;lat,lon[;...]" as a polygon
+func ParseGeofence(spec string) (*Geofence, error) {
+	if strings.Contains(spec, ";") {
+		var polygon []Coordinate
+
+		for _, vertex := range strings.Split(spec, ";") {
+			c, err := ParseCoordinate(vertex)
+			if err != nil {
+				return nil, err
+			}
+			polygon = append(polygon, c)
+		}
+		if len(polygon) < 3 {
+			return nil, errors.New("a polygon geofence needs at least 3 vertices")
+		}
+
+		return &Geofence{Polygon: polygon}, nil
+	}
+
+	parts := strings.Split(spec, ",")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid geofence %q, expected lat,lon,radius", spec)
+	}
+	center, err := parseLatLon(parts[0], parts[1])
+	if err != nil {
+		return nil, err
+	}
+	radius, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	if err != nil || radius <= 0 {
+		return nil, fmt.Errorf("invalid geofence radius %q", parts[2])
+	}
+
+	return &Geofence{Center: center, Radius: radius}, nil
+}
+
+// IsGeofence checks whether a GPS spec describes a geofence rather than a point
+func IsGeofence(spec string) bool {
+	return strings.Contains(spec, ";") || strings.Count(spec, ",") == 2
+}
+
+// ParsePosition parses a reported position "lat,lon" with an optional
+// accuracy radius in metres "lat,lon,radius"
+func ParsePosition(spec string) (Coordinate, int, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) == 3 {
+		accuracy, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+		if err != nil || accuracy < 0 {
+			return Coordinate{}, 0, fmt.Errorf("invalid accuracy radius %q", parts[2])
+		}
+		c, err := parseLatLon(parts[0], parts[1])
+
+		return c, accuracy, err
+	}
+
+	c, err := ParseCoordinate(spec)
+
+	return c, 0, err
+}
+
+// Contains checks whether the whole circle of the given accuracy around
+// the position lies inside the geofence, a negative accuracy is rejected
+func (g *Geofence) Contains(pos Coordinate, accuracy int) bool {
+	if accuracy < 0 {
+		return false
+	}
+	if len(g.Polygon) == 0 {
+		return g.Center.DistanceTo(pos)+float64(accuracy) <= float64(g.Radius)
+	}
+
+	inside := false
+	n := len(g.Polygon)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		xi, yi := g.Polygon[i].project(pos)
+		xj, yj := g.Polygon[j].project(pos)
+
+		if (yi > 0) != (yj > 0) && 0 < (xj-xi)*(0-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+		if accuracy > 0 && distanceToSegment(xi, yi, xj, yj) < float64(accuracy) {
+			return false
+		}
+	}
+
+	return inside
+}
+
+func (g *Geofence) String() string {
+	if len(g.Polygon) == 0 {
+		return fmt.Sprintf("%s,%d", g.Center, g.Radius)
+	}
+
+	var vertices []string
+	for _, v := range g.Polygon {
+		vertices = append(vertices, v.String())
+	}
+
+	return strings.Join(vertices, ";")
+}
+
+// distanceToSegment returns the distance from the origin to the segment (x1,y1)-(x2,y2)
+func distanceToSegment(x1, y1, x2, y2 float64) float64 {
+	dx, dy := x2-x1, y2-y1
+	t := 0.0
+	if dx != 0 || dy != 0 {
+		t = math.Max(0, math.Min(1, -(x1*dx+y1*dy)/(dx*dx+dy*dy)))
+	}
+
+	return math.Hypot(x1+t*dx, y1+t*dy)
+}
@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCoordinate(t *testing.T) {
+	c, err := ParseCoordinate("31.230416,121.473701")
+	assert.Nil(t, err)
+	assert.Equal(t, Coordinate{31230416, 121473701}, c)
+	assert.Equal(t, "31.230416,121.473701", c.String())
+
+	_, err = ParseCoordinate("91,0")
+	assert.NotNil(t, err)
+	_, err = ParseCoordinate("31.2")
+	assert.NotNil(t, err)
+
+	c, accuracy, err := ParsePosition("-33.8688,151.2093,25")
+	assert.Nil(t, err)
+	assert.Equal(t, Coordinate{-33868800, 151209300}, c)
+	assert.Equal(t, 25, accuracy)
+}
+
+func TestDistanceTo(t *testing.T) {
+	paris, _ := ParseCoordinate("48.8566,2.3522")
+	london, _ := ParseCoordinate("51.5074,-0.1278")
+
+	assert.InDelta(t, 343500, paris.DistanceTo(london), 1000)
+	assert.InDelta(t, 0, paris.DistanceTo(paris), 0.001)
+}
+
+func TestCircleGeofence(t *testing.T) {
+	fence, err := ParseGeofence("31.230416,121.473701,500")
+	assert.Nil(t, err)
+	assert.True(t, IsGeofence("31.230416,121.473701,500"))
+
+	near, _ := ParseCoordinate("31.231416,121.473701") // ~111m north
+	far, _ := ParseCoordinate("31.240416,121.473701")  // ~1.1km north
+
+	assert.True(t, fence.Contains(near, 0))
+	assert.True(t, fence.Contains(near, 300))
+	assert.False(t, fence.Contains(near, 450))
+	assert.False(t, fence.Contains(far, 0))
+	assert.False(t, fence.Contains(far, -2000))
+}
+
+func TestPolygonGeofence(t *testing.T) {
+	spec := "31.0,121.0;31.0,121.01;31.01,121.01;31.01,121.0"
+	fence, err := ParseGeofence(spec)
+	assert.Nil(t, err)
+	assert.True(t, IsGeofence(spec))
+	assert.Len(t, fence.Polygon, 4)
+
+	center, _ := ParseCoordinate("31.005,121.005")
+	edge, _ := ParseCoordinate("31.0001,121.005") // ~11m from the southern edge
+	outside, _ := ParseCoordinate("31.02,121.005")
+
+	assert.True(t, fence.Contains(center, 0))
+	assert.True(t, fence.Contains(center, 100))
+	assert.True(t, fence.Contains(edge, 0))
+	assert.False(t, fence.Contains(edge, 50))
+	assert.False(t, fence.Contains(outside, 0))
+	assert.False(t, fence.Contains(center, -1))
+
+	_, err = ParseGeofence("31.0,121.0;31.0,121.01")
+	assert.NotNil(t, err)
+}
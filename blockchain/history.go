@@ -0,0 +1,177 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// HistoryEntry is one operation in the life of a tokoin
+type HistoryEntry struct {
+	Height    int
+	Timestamp int64
+	TxID      []byte
+	Type      OpType
+	// Signers are the key hashes that signed the operation, none for a creation
+	Signers [][]byte
+	// Outpoint is the tokoin after the operation, nil once it is used up or revoked
+	Outpoint *Outpoint
+	// Changes describe how the conditions of the tokoin changed
+	Changes []string
+}
+
+// locatedTx is a transaction with the block that contains it
+type locatedTx struct {
+	tx    *Transaction
+	block *Block
+}
+
+// TokoinHistory follows the tokoin at the outpoint back to its creation and
+// forward through every operation that spent it, oldest first
+func (bc *Blockchain) TokoinHistory(outpoint Outpoint) ([]HistoryEntry, error) {
+	txs := make(map[string]locatedTx)
+	spentBy := make(map[string]string)
+
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			txs[hex.EncodeToString(tx.ID)] = locatedTx{tx, block}
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, vin := range tx.Vin {
+				spentBy[string(vin.Outpoint().Key())] = hex.EncodeToString(tx.ID)
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	created, ok := txs[hex.EncodeToString(outpoint.Txid)]
+	if !ok || outpoint.Vout < 0 || outpoint.Vout >= len(created.tx.Vout) {
+		return nil, fmt.Errorf("tokoin %s does not exist", outpoint)
+	}
+
+	// walk back to the creation, each operation spends the first input
+	var entries []HistoryEntry
+	for cur := outpoint; ; {
+		loc := txs[hex.EncodeToString(cur.Txid)]
+		var prev *TXOutput
+		if !loc.tx.IsCoinbase() {
+			vin := loc.tx.Vin[0]
+			prev = &txs[hex.EncodeToString(vin.Txid)].tx.Vout[vin.Vout]
+		}
+		next := cur
+		entries = append([]HistoryEntry{newHistoryEntry(loc, 0, prev, &next)}, entries...)
+
+		if loc.tx.IsCoinbase() {
+			break
+		}
+		cur = loc.tx.Vin[0].Outpoint()
+	}
+
+	// and forward to the operation that spent it last, the tokoin
+	// carries on in the first output of each operation
+	for cur := outpoint; ; {
+		spender, ok := spentBy[string(cur.Key())]
+		if !ok {
+			break
+		}
+		loc := txs[spender]
+		prev := &txs[hex.EncodeToString(cur.Txid)].tx.Vout[cur.Vout]
+
+		var next *Outpoint
+		if len(loc.tx.Vout) > 0 {
+			next = &Outpoint{loc.tx.ID, 0}
+		}
+		entries = append(entries, newHistoryEntry(loc, inputSpending(loc.tx, cur), prev, next))
+
+		if next == nil {
+			break
+		}
+		cur = *next
+	}
+
+	return entries, nil
+}
+
+// inputSpending returns the index of the input that spends the outpoint
+func inputSpending(tx *Transaction, outpoint Outpoint) int {
+	for i, vin := range tx.Vin {
+		if string(vin.Outpoint().Key()) == string(outpoint.Key()) {
+			return i
+		}
+	}
+
+	return 0
+}
+
+func newHistoryEntry(loc locatedTx, vin int, prev *TXOutput, next *Outpoint) HistoryEntry {
+	tx := loc.tx
+	entry := HistoryEntry{loc.block.Height, loc.block.Timestamp, tx.ID, tx.Type, nil, next, nil}
+
+	if !tx.IsCoinbase() {
+		entry.Signers = tx.Vin[vin].signers()
+	}
+
+	var out *TXOutput
+	if next != nil {
+		out = &tx.Vout[next.Vout]
+	}
+	entry.Changes = diffOutputs(prev, out)
+	if tx.Payload.Context != nil {
+		entry.Changes = append(entry.Changes, fmt.Sprintf("redeemed with %s", tx.Payload))
+	}
+
+	return entry
+}
+
+// signers returns the key hashes that signed the input
+func (vin *TXInput) signers() [][]byte {
+	var signers [][]byte
+
+	if len(vin.PubKey) > 0 {
+		signers = append(signers, wlt.HashPubKey(vin.PubKey))
+	}
+	for _, w := range vin.Witnesses {
+		signers = append(signers, wlt.HashPubKey(w.PubKey))
+	}
+
+	return signers
+}
+
+// diffOutputs describes the conditions that differ between two states of a
+// tokoin, a nil prev lists every condition and a nil out means it is gone
+func diffOutputs(prev, out *TXOutput) []string {
+	if out == nil {
+		return []string{"tokoin is used up or revoked"}
+	}
+
+	var changes []string
+	for _, f := range []struct {
+		name string
+		get  func(*TXOutput) string
+	}{
+		{"owner", (*TXOutput).owners},
+		{"holder", (*TXOutput).holderChain},
+		{"time", (*TXOutput).timeCondition},
+		{"id", (*TXOutput).idCondition},
+		{"gps", (*TXOutput).gpsCondition},
+		{"temperature", (*TXOutput).temperCondition},
+		{"expiry", (*TXOutput).expiry},
+		{"uses", (*TXOutput).uses},
+	} {
+		if prev == nil {
+			changes = append(changes, fmt.Sprintf("%s: %s", f.name, f.get(out)))
+		} else if before, after := f.get(prev), f.get(out); before != after {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", f.name, before, after))
+		}
+	}
+
+	return changes
+}
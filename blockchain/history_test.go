@@ -0,0 +1,52 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestTokoinHistory(t *testing.T) {
+	owner, holder := wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+
+	issue := NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)
+	mineTestBlock(t, bc, issue)
+	edit := EditPolicy(*owner, &urpo, Outpoint{issue.ID, 0}, "", "", "", "2..8", "", "")
+	mineTestBlock(t, bc, edit)
+	deposit := Deposit(owner, fmt.Sprintf("%s", holder.GetAddress()), &urpo, Outpoint{edit.ID, 0})
+	mineTestBlock(t, bc, deposit)
+	ctx := RedeemContext{Temperature: 5}
+	redeem := RedeemTokoin(*holder, ownerAddress, &urpo, Outpoint{deposit.ID, 0}, &ctx)
+	mineTestBlock(t, bc, redeem)
+	discard := RevocatTokoin(*owner, &urpo, Outpoint{redeem.ID, 0})
+	mineTestBlock(t, bc, discard)
+
+	// any point of the life of the tokoin gives the whole history
+	for _, outpoint := range []Outpoint{{issue.ID, 0}, {deposit.ID, 0}, {redeem.ID, 0}} {
+		entries, err := bc.TokoinHistory(outpoint)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, len(entries))
+
+		var types []OpType
+		for _, entry := range entries {
+			types = append(types, entry.Type)
+		}
+		assert.Equal(t, []OpType{OpCreate, OpEdit, OpDeposit, OpRedeem, OpDiscard}, types)
+		assert.Empty(t, entries[0].Signers)
+		assert.Equal(t, [][]byte{wlt.HashPubKey(owner.PublicKey)}, entries[1].Signers)
+		assert.Equal(t, [][]byte{wlt.HashPubKey(holder.PublicKey)}, entries[3].Signers)
+		assert.Equal(t, []string{"temperature: 37 -> 2..8"}, entries[1].Changes)
+		assert.Contains(t, entries[2].Changes, fmt.Sprintf("holder: none -> %x", wlt.HashPubKey(holder.PublicKey)))
+		assert.Equal(t, &Outpoint{redeem.ID, 0}, entries[3].Outpoint)
+		assert.Nil(t, entries[4].Outpoint)
+		assert.True(t, entries[1].Height < entries[2].Height)
+	}
+
+	_, err := bc.TokoinHistory(Outpoint{issue.ID, 1})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,165 @@
+package blockchain
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// ManifestRow describes one tokoin of a batch issuance, the conditions use
+// the same syntax as the editpolicy command and empty fields keep the defaults
+type ManifestRow struct {
+	Owner        string `json:"owner"`
+	Holder       string `json:"holder"`
+	Time         string `json:"time"`
+	ID           string `json:"id"`
+	GPS          string `json:"gps"`
+	Temperature  string `json:"temperature"`
+	ExpiryHeight string `json:"expiryheight"`
+	ExpiryTime   string `json:"expirytime"`
+	Uses         string `json:"uses"`
+}
+
+// Output builds the tokoin described by the row
+func (row ManifestRow) Output() (*TXOutput, error) {
+	if !wlt.ValidateAddress(row.Owner) {
+		return nil, fmt.Errorf("invalid owner address %q", row.Owner)
+	}
+	out := NewTXOutput(0, nil, Coordinate{}, 37, row.Owner)
+
+	if row.Holder != "" {
+		if !wlt.ValidateAddress(row.Holder) {
+			return nil, fmt.Errorf("invalid holder address %q", row.Holder)
+		}
+		out.Hold([]byte(row.Holder))
+	}
+
+	err := out.EditCondition(row.Time, row.ID, row.GPS, row.Temperature)
+	if err != nil {
+		return nil, err
+	}
+	err = out.EditExpiry(row.ExpiryHeight, row.ExpiryTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if row.Uses != "" {
+		uses, err := strconv.Atoi(row.Uses)
+		if err != nil || uses < 0 {
+			return nil, fmt.Errorf("invalid number of uses %q", row.Uses)
+		}
+		if uses > 0 {
+			out.Meter(uses)
+		}
+	}
+
+	return out, nil
+}
+
+// ReadManifest reads the tokoins of a JSON or CSV manifest file,
+// the format is chosen by the file extension
+func ReadManifest(path string) ([]TXOutput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []ManifestRow
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		rows, err = parseJSONManifest(f)
+	case ".csv":
+		rows, err = parseCSVManifest(f)
+	default:
+		return nil, fmt.Errorf("unknown manifest format %q, expected .json or .csv", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("manifest %s has no tokoins", path)
+	}
+
+	var outputs []TXOutput
+	for i, row := range rows {
+		out, err := row.Output()
+		if err != nil {
+			return nil, fmt.Errorf("manifest row %d: %s", i+1, err)
+		}
+		outputs = append(outputs, *out)
+	}
+
+	return outputs, nil
+}
+
+func parseJSONManifest(r io.Reader) ([]ManifestRow, error) {
+	var rows []ManifestRow
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// parseCSVManifest reads a CSV manifest whose header names the columns,
+// using the JSON field names of ManifestRow
+func parseCSVManifest(r io.Reader) ([]ManifestRow, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !isManifestColumn(header[i]) {
+			return nil, fmt.Errorf("unknown manifest column %q", column)
+		}
+	}
+
+	var rows []ManifestRow
+	for _, record := range records[1:] {
+		fields := make(map[string]string)
+		for i, column := range header {
+			fields[column] = strings.TrimSpace(record[i])
+		}
+
+		rows = append(rows, ManifestRow{
+			Owner:        fields["owner"],
+			Holder:       fields["holder"],
+			Time:         fields["time"],
+			ID:           fields["id"],
+			GPS:          fields["gps"],
+			Temperature:  fields["temperature"],
+			ExpiryHeight: fields["expiryheight"],
+			ExpiryTime:   fields["expirytime"],
+			Uses:         fields["uses"],
+		})
+	}
+
+	return rows, nil
+}
+
+func isManifestColumn(column string) bool {
+	switch column {
+	case "owner", "holder", "time", "id", "gps", "temperature", "expiryheight", "expirytime", "uses":
+		return true
+	default:
+		return false
+	}
+}
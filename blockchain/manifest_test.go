@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func writeTestManifest(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadManifest(t *testing.T) {
+	owner, holder := wlt.NewWallet(), wlt.NewWallet()
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	holderAddress := fmt.Sprintf("%s", holder.GetAddress())
+
+	path := writeTestManifest(t, "shipment.csv", fmt.Sprintf(
+		"owner,holder,gps,temperature,expiryheight,uses\n"+
+			"%s,%s,\"48.8584,2.2945,500\",2..8,100,3\n"+
+			"%s,,,,,\n", ownerAddress, holderAddress, ownerAddress))
+	outputs, err := ReadManifest(path)
+	assert.Nil(t, err)
+	assert.Len(t, outputs, 2)
+	assert.True(t, outputs[0].IsLockedWithKey(wlt.HashPubKey(owner.PublicKey)))
+	assert.True(t, outputs[0].IsHeldWithKey(wlt.HashPubKey(holder.PublicKey)))
+	assert.Equal(t, 500, outputs[0].Policy.Geofence.Radius)
+	assert.Equal(t, "2..8", outputs[0].Policy.Temperature.String())
+	assert.Equal(t, 100, outputs[0].ExpiryHeight)
+	assert.True(t, outputs[0].Metered)
+	assert.Equal(t, 3, outputs[0].RemainingUses)
+	assert.Nil(t, outputs[1].HolderKey)
+	assert.Equal(t, 37, outputs[1].Temperature)
+	assert.False(t, outputs[1].Metered)
+
+	path = writeTestManifest(t, "shipment.json", fmt.Sprintf(
+		`[{"owner": %q, "holder": %q, "time": "9..17"}, {"owner": %q, "expirytime": "1700000000"}]`,
+		ownerAddress, holderAddress, ownerAddress))
+	outputs, err = ReadManifest(path)
+	assert.Nil(t, err)
+	assert.Len(t, outputs, 2)
+	assert.Len(t, outputs[0].Policy.TimeWindows, 1)
+	assert.Equal(t, int64(1700000000), outputs[1].ExpiryTime)
+
+	// all the tokoins are minted by a single transaction
+	tx := NewIssuanceTX("", outputs)
+	assert.True(t, tx.IsCoinbase())
+	assert.Len(t, tx.Vout, 2)
+
+	// a bad row rejects the whole manifest
+	path = writeTestManifest(t, "bad.json", fmt.Sprintf(`[{"owner": %q}, {"owner": "nobody"}]`, ownerAddress))
+	_, err = ReadManifest(path)
+	assert.NotNil(t, err)
+	path = writeTestManifest(t, "bad.csv", fmt.Sprintf("owner,colour\n%s,red\n", ownerAddress))
+	_, err = ReadManifest(path)
+	assert.NotNil(t, err)
+	path = writeTestManifest(t, "empty.json", "[]")
+	_, err = ReadManifest(path)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,207 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/zhuaiballl/Go-Tokoin/utils"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// MultisigHash returns the owner key hash of a multi-signature tokoin,
+// which commits to the threshold, as a fixed width integer, and to the
+// owners in order
+func MultisigHash(owners [][]byte, threshold int) []byte {
+	data := utils.IntToHex(int64(threshold))
+	for _, owner := range owners {
+		data = append(data, owner...)
+	}
+
+	return wlt.HashPubKey(data)
+}
+
+// LockMultisig locks the output to threshold of the owner addresses
+func (out *TXOutput) LockMultisig(addresses []string, threshold int) error {
+	var owners [][]byte
+	for _, address := range addresses {
+		if !wlt.ValidateAddress(address) {
+			return fmt.Errorf("invalid owner address %q", address)
+		}
+		owner := utils.Base58Decode([]byte(address))
+		owners = append(owners, owner[1:len(owner)-4])
+	}
+
+	multisig := *out
+	multisig.Owners, multisig.Threshold = owners, threshold
+	multisig.PubKeyHash = MultisigHash(owners, threshold)
+	err := multisig.checkOwners()
+	if err != nil {
+		return err
+	}
+
+	*out = multisig
+	return nil
+}
+
+// IsMultisig checks whether the output is owned by several keys
+func (out *TXOutput) IsMultisig() bool {
+	return len(out.Owners) > 0
+}
+
+// IsOwnedBy checks whether the key is the owner or one of the owners of the output
+func (out *TXOutput) IsOwnedBy(pubKeyHash []byte) bool {
+	if !out.IsMultisig() {
+		return out.IsLockedWithKey(pubKeyHash)
+	}
+
+	return containsID(out.Owners, pubKeyHash)
+}
+
+// checkOwners checks that the owner key hash matches the owner set
+func (out *TXOutput) checkOwners() error {
+	if !out.IsMultisig() {
+		if out.Threshold != 0 {
+			return errors.New("a single owner tokoin cannot have a threshold")
+		}
+		return nil
+	}
+
+	if out.Threshold < 1 || out.Threshold > len(out.Owners) {
+		return fmt.Errorf("threshold %d is out of range for %d owners", out.Threshold, len(out.Owners))
+	}
+	for i, owner := range out.Owners {
+		if containsID(out.Owners[:i], owner) {
+			return fmt.Errorf("owner %x is duplicated", owner)
+		}
+	}
+	if !out.IsLockedWithKey(MultisigHash(out.Owners, out.Threshold)) {
+		return errors.New("owner key hash does not match the owners")
+	}
+
+	return nil
+}
+
+func (out *TXOutput) owners() string {
+	if !out.IsMultisig() {
+		return fmt.Sprintf("%x", out.PubKeyHash)
+	}
+
+	var owners []string
+	for _, owner := range out.Owners {
+		owners = append(owners, fmt.Sprintf("%x", owner))
+	}
+
+	return fmt.Sprintf("%d of %s", out.Threshold, strings.Join(owners, ", "))
+}
+
+// ownerSignatures counts the distinct owners of the output who signed the input
+func (vin *TXInput) ownerSignatures(out *TXOutput) int {
+	var signers [][]byte
+
+	for _, w := range vin.Witnesses {
+		signer := wlt.HashPubKey(w.PubKey)
+		if out.IsOwnedBy(signer) && !containsID(signers, signer) {
+			signers = append(signers, signer)
+		}
+	}
+
+	return len(signers)
+}
+
+// usesWitnesses checks whether the input is signed by witnesses rather than a single key
+func usesWitnesses(role Role, out *TXOutput) bool {
+	return role == RoleOwner && out.IsMultisig()
+}
+
+// MissingSignatures returns how many more signatures the transaction needs
+func (tx *Transaction) MissingSignatures(prevTXs map[string]Transaction) int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	if tx.signsPayload() && len(tx.Payload.OwnerSignature) == 0 {
+		return 1
+	}
+
+	missing := 0
+	role := tx.Type.SignerRole()
+	for _, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			continue
+		}
+		prevOut := prevTx.Vout[vin.Vout]
+
+		if usesWitnesses(role, &prevOut) {
+			if n := prevOut.Threshold - vin.ownerSignatures(&prevOut); n > 0 {
+				missing += n
+			}
+		} else if len(vin.Signature) == 0 {
+			missing++
+		}
+	}
+
+	return missing
+}
+
+// Combine merges the signatures of another copy of the same transaction
+func (tx *Transaction) Combine(other *Transaction) error {
+	if !bytes.Equal(tx.ID, other.ID) || len(tx.Vin) != len(other.Vin) {
+		return fmt.Errorf("transaction %x is not a copy of %x", other.ID, tx.ID)
+	}
+
+	for i, vin := range other.Vin {
+		if len(tx.Vin[i].Signature) == 0 {
+			tx.Vin[i].Signature = vin.Signature
+		}
+		for _, w := range vin.Witnesses {
+			tx.Vin[i].addWitness(w)
+		}
+	}
+
+	return nil
+}
+
+// addWitness adds a signature to the input, replacing an earlier one by the same key
+func (vin *TXInput) addWitness(w Witness) {
+	for i := range vin.Witnesses {
+		if bytes.Equal(vin.Witnesses[i].PubKey, w.PubKey) {
+			vin.Witnesses[i] = w
+			return
+		}
+	}
+
+	vin.Witnesses = append(vin.Witnesses, w)
+}
+
+// publicKeyBytes encodes a public key the way wallets do
+func publicKeyBytes(privKey ecdsa.PrivateKey) []byte {
+	pubKey := make([]byte, 64)
+	privKey.PublicKey.X.FillBytes(pubKey[:32])
+	privKey.PublicKey.Y.FillBytes(pubKey[32:])
+
+	return pubKey
+}
+
+// MissingSignatures returns how many more signatures the transaction needs
+func (bc *Blockchain) MissingSignatures(tx *Transaction) int {
+	prevTXs := make(map[string]Transaction)
+
+	for _, vin := range tx.Vin {
+		if tx.IsCoinbase() {
+			break
+		}
+		prevTX, err := bc.FindTransaction(vin.Txid)
+		if err != nil {
+			log.Panic(err)
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+
+	return tx.MissingSignatures(prevTXs)
+}
@@ -0,0 +1,66 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestMultisigOwners(t *testing.T) {
+	a, b, c, stranger := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, a)
+	urpo := URPOSet{bc}
+	addresses := []string{fmt.Sprintf("%s", a.GetAddress()), fmt.Sprintf("%s", b.GetAddress()), fmt.Sprintf("%s", c.GetAddress())}
+
+	tokoin := NewTXOutput(0, nil, Coordinate{}, 37, addresses[0])
+	assert.NotNil(t, tokoin.LockMultisig(addresses, 4))
+	assert.NotNil(t, tokoin.LockMultisig(append(addresses, addresses[0]), 2))
+	assert.Nil(t, tokoin.LockMultisig(addresses, 2))
+	assert.NotEqual(t, tokoin.PubKeyHash, MultisigHash(tokoin.Owners, 2+256))
+	issue := NewIssuanceTX("", []TXOutput{*tokoin})
+	mineTestBlock(t, bc, issue)
+	outpoint := Outpoint{issue.ID, 0}
+
+	// one owner is not enough, nor is a stranger or the same owner twice
+	edit := EditPolicy(*a, &urpo, outpoint, "", "", "", "2..8", "", "")
+	assert.Equal(t, 1, bc.MissingSignatures(edit))
+	assert.NotNil(t, bc.ValidateTransaction(edit))
+	bc.SignTransaction(edit, stranger.PrivateKey)
+	bc.SignTransaction(edit, a.PrivateKey)
+	assert.Equal(t, 1, bc.MissingSignatures(edit))
+	assert.NotNil(t, bc.ValidateTransaction(edit))
+
+	// the owners sign their own copies, which are combined
+	copied := DeserializeTransaction(edit.Serialize())
+	copied.Vin[0].Witnesses = nil
+	bc.SignTransaction(&copied, c.PrivateKey)
+	assert.Nil(t, edit.Combine(&copied))
+	assert.Equal(t, 0, bc.MissingSignatures(edit))
+	assert.Nil(t, bc.ValidateTransaction(edit))
+	assert.NotNil(t, edit.Combine(issue))
+
+	// a forged witness invalidates the transaction
+	forged := DeserializeTransaction(edit.Serialize())
+	forged.Vin[0].Witnesses[0].Signature = forged.Vin[0].Witnesses[1].Signature
+	assert.NotNil(t, bc.ValidateTransaction(&forged))
+
+	// and the owners cannot be changed by an edit
+	changed := DeserializeTransaction(edit.Serialize())
+	assert.Nil(t, changed.Vout[0].LockMultisig(addresses[:2], 1))
+	assert.NotNil(t, changed.CheckOperation(map[string]Transaction{fmt.Sprintf("%x", issue.ID): *issue}))
+	mineTestBlock(t, bc, edit)
+
+	owner := fmt.Sprintf("%s", wlt.KeyHashToAddress(tokoin.PubKeyHash))
+	holder := fmt.Sprintf("%s", stranger.GetAddress())
+	deposit := Deposit(b, holder, &urpo, Outpoint{edit.ID, 0})
+	bc.SignTransaction(deposit, c.PrivateKey)
+	mineTestBlock(t, bc, deposit)
+	assert.Panics(t, func() { RevokeHolder(*stranger, owner, holder, &urpo) })
+	revoke := RevokeHolder(*a, owner, holder, &urpo)
+	assert.NotNil(t, bc.ValidateTransaction(revoke))
+	bc.SignTransaction(revoke, b.PrivateKey)
+	mineTestBlock(t, bc, revoke)
+	assert.True(t, urpo.IsRevoked(tokoin.PubKeyHash, wlt.HashPubKey(stranger.PublicKey)))
+}
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -17,6 +18,10 @@ var knownNodes = []string{"localhost:3000"}
 var blocksInTransit = [][]byte{}
 var mempool = make(map[string]Transaction)
 
+// mempoolMu guards the mempool and the blocks in transit, which the
+// connections and the miner use concurrently
+var mempoolMu sync.Mutex
+
 type AddrPayload struct {
 	AddrList []string
 }
@@ -75,10 +80,71 @@ func bytesToCommand(bytes []byte) string {
 	return fmt.Sprintf("%s", command)
 }
 
+// miningAbort is closed to stop mining the block in progress
+var (
+	miningMu    sync.Mutex
+	miningAbort chan struct{}
+)
+
+// startMining returns the channel that abortMining closes, the block
+// mined until then is abandoned for the new one
+func startMining() <-chan struct{} {
+	miningMu.Lock()
+	defer miningMu.Unlock()
+
+	if miningAbort != nil {
+		close(miningAbort)
+	}
+	miningAbort = make(chan struct{})
+	return miningAbort
+}
+
+// abortMining stops mining the block in progress, if any
+func abortMining() {
+	miningMu.Lock()
+	defer miningMu.Unlock()
+
+	if miningAbort != nil {
+		close(miningAbort)
+		miningAbort = nil
+	}
+}
+
 func DeleteMempoolTx(txID string) {
+	mempoolMu.Lock()
+	defer mempoolMu.Unlock()
+
 	delete(mempool, txID)
 }
 
+// mempoolSize returns the number of transactions in the mempool
+func mempoolSize() int {
+	mempoolMu.Lock()
+	defer mempoolMu.Unlock()
+
+	return len(mempool)
+}
+
+// updateMempool returns the transactions of the disconnected blocks to the
+// mempool and removes the ones included in the connected blocks
+func updateMempool(disconnected, connected []*Block) {
+	mempoolMu.Lock()
+	defer mempoolMu.Unlock()
+
+	for _, block := range disconnected {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				mempool[hex.EncodeToString(tx.ID)] = *tx
+			}
+		}
+	}
+	for _, block := range connected {
+		for _, tx := range block.Transactions {
+			delete(mempool, hex.EncodeToString(tx.ID))
+		}
+	}
+}
+
 func extractCommand(request []byte) []byte {
 	return request[:config.CommandLength]
 }
@@ -204,18 +270,24 @@ func handleBlock(request []byte, bchain *Blockchain) {
 	block := DeserializeBlock(blockData)
 
 	fmt.Println("Recevied a new block!")
-	bchain.AddBlock(block)
-
-	fmt.Printf("Added block %x\n", block.Hash)
+	err = bchain.AddBlock(block)
+	if err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+	} else {
+		fmt.Printf("Added block %x\n", block.Hash)
+		// the block being mined no longer extends the tip
+		if bytes.Equal(bchain.currentTip(), block.Hash) {
+			abortMining()
+		}
+	}
 
+	mempoolMu.Lock()
+	defer mempoolMu.Unlock()
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
 		sendGetData(payload.AddrFrom, "block", blockHash)
 
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		URPOSet := URPOSet{bchain}
-		URPOSet.Reindex()
 	}
 }
 
@@ -232,10 +304,23 @@ func handleInv(request []byte, bchain *Blockchain) {
 
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
+	mempoolMu.Lock()
+	defer mempoolMu.Unlock()
+
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+		// the inventory lists the newest block first, blocks are requested
+		// oldest first so that each one extends the tip when it arrives
+		blocksInTransit = [][]byte{}
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if _, err := bchain.GetBlock(payload.Items[i]); err != nil {
+				blocksInTransit = append(blocksInTransit, payload.Items[i])
+			}
+		}
+		if len(blocksInTransit) == 0 {
+			return
+		}
 
-		blockHash := payload.Items[0]
+		blockHash := blocksInTransit[0]
 		sendGetData(payload.AddrFrom, "block", blockHash)
 
 		newInTransit := [][]byte{}
@@ -293,7 +378,9 @@ func handleGetData(request []byte, bchain *Blockchain) {
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
+		mempoolMu.Lock()
 		tx := mempool[txID]
+		mempoolMu.Unlock()
 
 		SendTx(payload.AddrFrom, &tx)
 		// delete(mempool, txID)
@@ -313,7 +400,9 @@ func handleTx(request []byte, bchain *Blockchain) {
 
 	txData := payload.Transaction
 	tx := DeserializeTransaction(txData)
+	mempoolMu.Lock()
 	mempool[hex.EncodeToString(tx.ID)] = tx
+	mempoolMu.Unlock()
 
 	if config.NodeAddress == knownNodes[0] {
 		for _, node := range knownNodes {
@@ -322,54 +411,57 @@ func handleTx(request []byte, bchain *Blockchain) {
 			}
 		}
 	}
-	if config.NodeAddress == proposer(bchain.GetBestHeight(), 0) {
-		//if len(mempool) >= 1 && len(miningAddress) > 1 {
-	MineTransactions:
-		var txs []*Transaction
-
-		for id := range mempool {
-			tx := mempool[id]
-			if bchain.VerifyTransaction(&tx) {
-				txs = append(txs, &tx)
-			} else {
-				fmt.Println("bad transaction")
-				fmt.Printf("%s\n", tx)
-			}
-		}
+	err = bchain.consensus.ProposeBlock(bchain)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
 
-		if len(txs) == 0 {
-			fmt.Println("All transactions are invalid! Waiting for new ones...")
-			return
+// mempoolBlockTxs returns the valid transactions of the mempool followed by
+// a coinbase transaction to the mining address if there is one, or nil if
+// none is valid. Invalid ones are dropped, except those spending another
+// transaction of the mempool, which are left for a later block.
+func mempoolBlockTxs(bchain *Blockchain) []*Transaction {
+	var txs []*Transaction
+	validator := NewTxValidator(bchain)
+
+	mempoolMu.Lock()
+	defer mempoolMu.Unlock()
+	for id := range mempool {
+		tx := mempool[id]
+		err := validator.Accept(&tx)
+		if err == nil {
+			txs = append(txs, &tx)
+		} else if !spendsMempool(&tx) {
+			fmt.Printf("bad transaction: %s\n", err)
+			fmt.Printf("%s\n", tx)
+			delete(mempool, id)
 		}
+	}
 
-		cbTx := NewCoinbaseTX(config.MiningAddress, "", 0, nil, 0, 0)
-		txs = append(txs, cbTx)
-
-		newBlock := bchain.MineBlock(txs)
-		URPOSet := URPOSet{bchain}
-		URPOSet.Reindex()
-
-		fmt.Println("New block is mined!")
+	if len(txs) == 0 {
+		fmt.Println("All transactions are invalid! Waiting for new ones...")
+		return nil
+	}
 
-		for _, tx := range txs {
-			txID := hex.EncodeToString(tx.ID)
-			delete(mempool, txID)
-		}
+	if len(config.MiningAddress) > 0 {
+		cbTx := NewCoinbaseTX(config.MiningAddress, "", 0, nil, Coordinate{}, 0, 0)
+		txs = append(txs, cbTx)
+	}
 
-		//for _, node := range knownNodes {
-		//	if node != nodeAddress {
-		//		sendInv(node, "block", [][]byte{newBlock.Hash})
-		//	}
-		//}
-		SetBlock(newBlock)
+	return txs
+}
 
-		if len(mempool) > 0 {
-			goto MineTransactions
+// spendsMempool checks whether the transaction spends an output of a
+// transaction of the mempool, mempoolMu must be held
+func spendsMempool(tx *Transaction) bool {
+	for _, vin := range tx.Vin {
+		if _, ok := mempool[hex.EncodeToString(vin.Txid)]; ok {
+			return true
 		}
-		//}
 	}
-	SetHeight(bchain.GetBestHeight())
-	StartRound(0)
+
+	return false
 }
 
 func handleVersion(request []byte, bchain *Blockchain) {
@@ -422,28 +514,19 @@ func handleConnection(conn net.Conn, bchain *Blockchain) {
 		handleTx(request, bchain)
 	case "version":
 		handleVersion(request, bchain)
-	case "proposal":
-		handleProposal(request, bchain)
-	case "propoBlo":
-		handlePropoBlock(request, bchain)
-	case "getPropo":
-		handleGetProposal(request, bchain)
-	case "prevote":
-		handlePrevote(request, bchain)
-	case "precommit":
-		handlePrecommit(request, bchain)
 	default:
-		fmt.Println("Unknown command!")
+		if !bchain.consensus.HandleMessage(bchain, command, request) {
+			fmt.Println("Unknown command!")
+		}
 	}
 
 	conn.Close()
 }
 
-// StartServer starts a node
-func StartServer(nodeID, minerAddress string) {
+// StartServer starts a node that agrees on blocks with the consensus engine
+func StartServer(nodeID, minerAddress string, engine ConsensusEngine) {
 	config.NodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	config.MiningAddress = minerAddress
-	initTendermint()
 	ln, err := net.Listen(config.Protocol, config.NodeAddress)
 	if err != nil {
 		log.Panic(err)
@@ -451,8 +534,8 @@ func StartServer(nodeID, minerAddress string) {
 	defer ln.Close()
 
 	bc := NewBlockchain(nodeID)
-
-	curHeight = bc.GetBestHeight()
+	bc.SetConsensus(engine)
+	engine.Start(bc)
 
 	if config.NodeAddress != knownNodes[0] {
 		sendVersion(knownNodes[0], bc)
@@ -0,0 +1,227 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// OpType tags the tokoin operation performed by a transaction
+type OpType int
+
+const (
+	OpCreate OpType = iota
+	OpDeposit
+	OpEdit
+	OpRedeem
+	OpTransfer
+	OpDiscard
+	OpDelegate
+	OpRevokeHolder
+)
+
+var opNames = map[OpType]string{
+	OpCreate:       "create",
+	OpDeposit:      "deposit",
+	OpEdit:         "edit",
+	OpRedeem:       "redeem",
+	OpTransfer:     "transfer",
+	OpDiscard:      "discard",
+	OpDelegate:     "delegate",
+	OpRevokeHolder: "revokeholder",
+}
+
+func (op OpType) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", int(op))
+}
+
+// Role is the party of a tokoin entitled to sign an operation
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleOwner
+	RoleHolder
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleOwner:
+		return "owner"
+	case RoleHolder:
+		return "holder"
+	default:
+		return "none"
+	}
+}
+
+// SignerRole returns the role that must sign the inputs of the operation
+func (op OpType) SignerRole() Role {
+	switch op {
+	case OpDeposit, OpEdit, OpDiscard, OpRevokeHolder:
+		return RoleOwner
+	case OpRedeem, OpTransfer, OpDelegate:
+		return RoleHolder
+	default:
+		return RoleNone
+	}
+}
+
+// KeyForRole returns the key hash of the party playing the role for the output
+func (out *TXOutput) KeyForRole(role Role) []byte {
+	switch role {
+	case RoleOwner:
+		return out.PubKeyHash
+	case RoleHolder:
+		return out.HolderKey
+	default:
+		return nil
+	}
+}
+
+// OpPayload carries the operation-specific data of a transaction
+type OpPayload struct {
+	// Holder is the new holder key of a deposit, a transfer or a delegation,
+	// or the holder revoked by a revoke holder transaction
+	Holder []byte
+	// Context is the environment the holder reported when redeeming
+	Context *RedeemContext
+	// Owner is the owner key hash publishing a revoke holder transaction
+	Owner []byte
+	// OwnerPubKey and OwnerSignature authorise a revoke holder transaction
+	// that spends no tokoin, the owner signs the transaction ID
+	OwnerPubKey    []byte
+	OwnerSignature []byte
+}
+
+func (p OpPayload) String() string {
+	var fields []string
+
+	if len(p.Holder) > 0 {
+		fields = append(fields, fmt.Sprintf("holder %x", p.Holder))
+	}
+	if len(p.Owner) > 0 {
+		fields = append(fields, fmt.Sprintf("owner %x", p.Owner))
+	}
+	if p.Context != nil {
+		fields = append(fields, fmt.Sprintf("context time=%d id=%s gps=%s accuracy=%d temperature=%d",
+			p.Context.Time, p.Context.ID, p.Context.GPS, p.Context.Accuracy, p.Context.Temperature))
+	}
+
+	return strings.Join(fields, ", ")
+}
+
+// CheckOperation checks the outputs of a transaction against the rules of
+// its operation and the outputs it spends
+func (tx *Transaction) CheckOperation(prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		if tx.Type != OpCreate {
+			return fmt.Errorf("coinbase transaction cannot %s a tokoin", tx.Type)
+		}
+		for _, out := range tx.Vout {
+			err := out.checkOwners()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var prevOuts []TXOutput
+	for _, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return fmt.Errorf("input %x:%d does not exist", vin.Txid, vin.Vout)
+		}
+		prevOuts = append(prevOuts, prevTx.Vout[vin.Vout])
+	}
+
+	for _, out := range tx.Vout {
+		err := out.checkOwners()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch tx.Type {
+	case OpCreate:
+		return errors.New("tokoins can only be created by a coinbase transaction")
+	case OpDiscard:
+		if len(tx.Vin) == 0 || len(tx.Vout) != 0 {
+			return errors.New("discard must spend tokoins without creating any")
+		}
+		return nil
+	case OpRevokeHolder:
+		return tx.checkRevocation(prevOuts)
+	case OpDelegate:
+		if len(prevOuts) != 1 {
+			return errors.New("delegate must spend exactly one tokoin and create two")
+		}
+		return tx.checkDelegation(prevOuts[0])
+	case OpRedeem:
+		// a delegation is used up by its redemption
+		if len(prevOuts) == 1 && prevOuts[0].IsDelegated() {
+			if len(tx.Vout) != 0 {
+				return errors.New("redeeming a delegated tokoin cannot create one")
+			}
+			if tx.Payload.Context == nil || !prevOuts[0].CheckCondition(tx.Payload.Context) {
+				return errors.New("redeem condition not satisfied")
+			}
+			return nil
+		}
+	}
+
+	if len(tx.Vin) != 1 || len(tx.Vout) != 1 {
+		return fmt.Errorf("%s must spend exactly one tokoin and create one", tx.Type)
+	}
+	prev, out := prevOuts[0], tx.Vout[0]
+	if prev.IsDelegated() && tx.Type != OpRedeem {
+		return fmt.Errorf("a delegated tokoin cannot be used for %s", tx.Type)
+	}
+
+	switch tx.Type {
+	case OpDeposit, OpTransfer:
+		if len(tx.Payload.Holder) == 0 || !out.IsHeldWithKey(tx.Payload.Holder) {
+			return fmt.Errorf("%s must set the holder named in its payload", tx.Type)
+		}
+		if !out.SameTerms(&prev) {
+			return fmt.Errorf("%s can only change the holder", tx.Type)
+		}
+	case OpEdit:
+		if !out.IsLockedWithKey(prev.PubKeyHash) {
+			return errors.New("edit cannot change the owner")
+		}
+		if !out.IsHeldWithKey(prev.HolderKey) {
+			return errors.New("edit cannot change the holder")
+		}
+		if !out.SameHolding(&prev) {
+			return errors.New("edit can only change the redemption conditions and the expiry")
+		}
+	case OpRedeem:
+		if len(out.HolderKey) != 0 {
+			return errors.New("redeem must clear the holder")
+		}
+		expected := prev
+		if prev.Metered {
+			if prev.RemainingUses <= 0 {
+				return errors.New("tokoin has no uses left")
+			}
+			expected.RemainingUses--
+		}
+		if !out.SameTerms(&expected) {
+			return errors.New("redeem can only clear the holder and use up one redemption")
+		}
+		if tx.Payload.Context == nil || !prev.CheckCondition(tx.Payload.Context) {
+			return errors.New("redeem condition not satisfied")
+		}
+	default:
+		return fmt.Errorf("unknown operation %s", tx.Type)
+	}
+
+	return nil
+}
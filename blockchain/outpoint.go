@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Outpoint addresses a single tokoin by the transaction and the index of the output that created it
+type Outpoint struct {
+	Txid []byte
+	Vout int
+}
+
+// ParseOutpoint parses TXID:VOUT, the index defaults to 0 when omitted
+func ParseOutpoint(s string) (Outpoint, error) {
+	txid, vout := s, "0"
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		txid, vout = s[:i], s[i+1:]
+	}
+
+	id, err := hex.DecodeString(txid)
+	if err != nil || len(id) == 0 {
+		return Outpoint{}, fmt.Errorf("invalid txid %q", txid)
+	}
+	index, err := strconv.Atoi(vout)
+	if err != nil || index < 0 {
+		return Outpoint{}, fmt.Errorf("invalid output index %q", vout)
+	}
+
+	return Outpoint{id, index}, nil
+}
+
+func (op Outpoint) String() string {
+	return fmt.Sprintf("%x:%d", op.Txid, op.Vout)
+}
+
+// Key returns the chainstate key of the outpoint, the txid followed by the big-endian index
+func (op Outpoint) Key() []byte {
+	key := make([]byte, len(op.Txid)+4)
+	copy(key, op.Txid)
+	binary.BigEndian.PutUint32(key[len(op.Txid):], uint32(op.Vout))
+
+	return key
+}
+
+// OutpointFromKey decodes a chainstate key
+func OutpointFromKey(key []byte) Outpoint {
+	n := len(key) - 4
+	txid := make([]byte, n)
+	copy(txid, key[:n])
+
+	return Outpoint{txid, int(binary.BigEndian.Uint32(key[n:]))}
+}
@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestParseOutpoint(t *testing.T) {
+	op, err := ParseOutpoint("ab01:3")
+	assert.Nil(t, err)
+	assert.Equal(t, Outpoint{[]byte{0xab, 0x01}, 3}, op)
+	assert.Equal(t, "ab01:3", op.String())
+	assert.Equal(t, op, OutpointFromKey(op.Key()))
+
+	// the index defaults to the first output
+	op, err = ParseOutpoint("ab01")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, op.Vout)
+
+	for _, s := range []string{"", ":1", "xyz:1", "ab01:", "ab01:-1", "ab01:one"} {
+		_, err = ParseOutpoint(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestMultiOutputTokoins(t *testing.T) {
+	owner, holder := wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	holderAddress := fmt.Sprintf("%s", holder.GetAddress())
+
+	first := NewTXOutput(0, nil, Coordinate{}, 37, ownerAddress)
+	second := NewTXOutput(0, nil, Coordinate{}, 5, ownerAddress)
+	issue := NewIssuanceTX("", []TXOutput{*first, *second})
+	mineTestBlock(t, bc, issue)
+
+	// each output is addressed by its own outpoint
+	out, err := urpo.FindOutput(Outpoint{issue.ID, 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, out.Temperature)
+	_, err = urpo.FindOutput(Outpoint{issue.ID, 2})
+	assert.NotNil(t, err)
+
+	deposit := Deposit(owner, holderAddress, &urpo, Outpoint{issue.ID, 1})
+	assert.Equal(t, 1, deposit.Vin[0].Vout)
+	mineTestBlock(t, bc, deposit)
+
+	// spending the second output keeps the first one where it was
+	assert.False(t, urpo.IsUnspent(Outpoint{issue.ID, 1}))
+	out, err = urpo.FindOutput(Outpoint{issue.ID, 0})
+	assert.Nil(t, err)
+	assert.Equal(t, 37, out.Temperature)
+	_, err = urpo.FindOutput(Outpoint{issue.ID, 1})
+	assert.NotNil(t, err)
+	assert.NotNil(t, bc.ValidateTransaction(deposit))
+
+	edit := EditPolicy(*owner, &urpo, Outpoint{issue.ID, 0}, "", "", "", "2..8", "", "")
+	assert.Nil(t, bc.ValidateTransaction(edit))
+	ctx := RedeemContext{Temperature: 5}
+	redeem := RedeemTokoin(*holder, ownerAddress, &urpo, Outpoint{deposit.ID, 0}, &ctx)
+	assert.Nil(t, bc.ValidateTransaction(redeem))
+
+	urpo.Reindex()
+	assert.True(t, urpo.IsUnspent(Outpoint{issue.ID, 0}))
+	assert.False(t, urpo.IsUnspent(Outpoint{issue.ID, 1}))
+	assert.True(t, urpo.IsUnspent(Outpoint{deposit.ID, 0}))
+
+	// a chainstate keyed by txid is rebuilt when the blockchain is opened
+	err = bc.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(urpoBucket))
+		if err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte(urpoBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(issue.ID, TXOutputs{issue.Vout}.Serialize())
+	})
+	assert.Nil(t, err)
+	assert.True(t, urpo.upgrade())
+	assert.True(t, urpo.IsUnspent(Outpoint{issue.ID, 0}))
+	assert.False(t, urpo.upgrade())
+}
@@ -0,0 +1,203 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rangeSeparator separates the bounds of a range in policy specs, e.g. "2..8"
+const rangeSeparator = ".."
+
+// Range is an inclusive numeric interval, a nil bound is open
+type Range struct {
+	Min *int
+	Max *int
+}
+
+// Contains checks whether v lies within the range
+func (r *Range) Contains(v int) bool {
+	if r.Min != nil && v < *r.Min {
+		return false
+	}
+	if r.Max != nil && v > *r.Max {
+		return false
+	}
+
+	return true
+}
+
+func (r *Range) String() string {
+	var min, max string
+
+	if r.Min != nil {
+		min = strconv.Itoa(*r.Min)
+	}
+	if r.Max != nil {
+		max = strconv.Itoa(*r.Max)
+	}
+
+	return min + rangeSeparator + max
+}
+
+// TimeWindow is an inclusive time interval, a window whose start is after
+// its end wraps around (e.g. 2200..600 for a night shift)
+type TimeWindow struct {
+	Start int
+	End   int
+}
+
+// Contains checks whether t lies within the window
+func (w TimeWindow) Contains(t int) bool {
+	if w.Start <= w.End {
+		return t >= w.Start && t <= w.End
+	}
+
+	return t >= w.Start || t <= w.End
+}
+
+func (w TimeWindow) String() string {
+	return fmt.Sprintf("%d%s%d", w.Start, rangeSeparator, w.End)
+}
+
+// Policy holds the redemption predicates of a tokoin.
+// A condition without a predicate must match the value stored in the output exactly.
+type Policy struct {
+	TimeWindows []TimeWindow
+	IDs         [][]byte
+	Geofence    *Geofence
+	Temperature *Range
+}
+
+// String returns a human-readable representation of the policy
+func (p Policy) String() string {
+	var preds []string
+
+	if len(p.TimeWindows) > 0 {
+		preds = append(preds, fmt.Sprintf("time in %s", joinTimeWindows(p.TimeWindows)))
+	}
+	if len(p.IDs) > 0 {
+		preds = append(preds, fmt.Sprintf("id in {%s}", joinIDs(p.IDs)))
+	}
+	if p.Geofence != nil {
+		preds = append(preds, fmt.Sprintf("gps in [%s]", p.Geofence))
+	}
+	if p.Temperature != nil {
+		preds = append(preds, fmt.Sprintf("temperature in [%s]", p.Temperature))
+	}
+
+	if len(preds) == 0 {
+		return "exact match"
+	}
+
+	return strings.Join(preds, ", ")
+}
+
+// RedeemContext is the environment reported by a holder when redeeming a tokoin.
+// Accuracy is the uncertainty of the reported GPS position in metres.
+type RedeemContext struct {
+	Time        int
+	ID          []byte
+	GPS         Coordinate
+	Accuracy    int
+	Temperature int
+}
+
+// IsRange checks whether a condition spec describes a range rather than an exact value
+func IsRange(spec string) bool {
+	return strings.Contains(spec, rangeSeparator)
+}
+
+// ParseRange parses a range spec of the form "MIN..MAX", "MIN.." or "..MAX"
+func ParseRange(spec string) (*Range, error) {
+	bounds := strings.Split(spec, rangeSeparator)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid range %q", spec)
+	}
+
+	var r Range
+	if bounds[0] != "" {
+		min, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: %v", spec, err)
+		}
+		r.Min = &min
+	}
+	if bounds[1] != "" {
+		max, err := strconv.Atoi(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: %v", spec, err)
+		}
+		r.Max = &max
+	}
+
+	if r.Min == nil && r.Max == nil {
+		return nil, errors.New("range has no bounds")
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return nil, fmt.Errorf("invalid range %q: min is greater than max", spec)
+	}
+
+	return &r, nil
+}
+
+// ParseTimeWindows parses a comma-separated list of "START..END" windows
+func ParseTimeWindows(spec string) ([]TimeWindow, error) {
+	var windows []TimeWindow
+
+	for _, w := range strings.Split(spec, ",") {
+		r, err := ParseRange(w)
+		if err != nil {
+			return nil, err
+		}
+		if r.Min == nil || r.Max == nil {
+			return nil, fmt.Errorf("time window %q must have both a start and an end", w)
+		}
+		windows = append(windows, TimeWindow{*r.Min, *r.Max})
+	}
+
+	return windows, nil
+}
+
+// ParseIDs parses a comma-separated ID allow-list
+func ParseIDs(spec string) [][]byte {
+	var ids [][]byte
+
+	for _, id := range strings.Split(spec, ",") {
+		ids = append(ids, []byte(id))
+	}
+
+	return ids
+}
+
+func containsID(ids [][]byte, id []byte) bool {
+	for _, allowed := range ids {
+		if bytes.Compare(allowed, id) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func joinTimeWindows(windows []TimeWindow) string {
+	var parts []string
+
+	for _, w := range windows {
+		parts = append(parts, w.String())
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func joinIDs(ids [][]byte) string {
+	var parts []string
+
+	for _, id := range ids {
+		parts = append(parts, string(id))
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("2..8")
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(2))
+	assert.True(t, r.Contains(8))
+	assert.False(t, r.Contains(9))
+	assert.Equal(t, "2..8", r.String())
+
+	r, err = ParseRange("..8")
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(-40))
+	assert.False(t, r.Contains(9))
+
+	r, err = ParseRange("-5..")
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(100))
+	assert.False(t, r.Contains(-6))
+
+	_, err = ParseRange("..")
+	assert.NotNil(t, err)
+	_, err = ParseRange("8..2")
+	assert.NotNil(t, err)
+	_, err = ParseRange("a..2")
+	assert.NotNil(t, err)
+}
+
+func TestTimeWindows(t *testing.T) {
+	windows, err := ParseTimeWindows("900..1200,1300..1700")
+	assert.Nil(t, err)
+	assert.Equal(t, []TimeWindow{{900, 1200}, {1300, 1700}}, windows)
+
+	night := TimeWindow{2200, 600}
+	assert.True(t, night.Contains(2300))
+	assert.True(t, night.Contains(100))
+	assert.False(t, night.Contains(1200))
+
+	_, err = ParseTimeWindows("900..")
+	assert.NotNil(t, err)
+}
+
+func TestCheckCondition(t *testing.T) {
+	lab := Coordinate{31230416, 121473701}
+	out := TXOutput{Time: 0, ID: []byte("lab"), GPS: lab, Temperature: 37}
+
+	ctx := RedeemContext{Time: 0, ID: []byte("lab"), GPS: lab, Temperature: 37}
+	assert.True(t, out.CheckCondition(&ctx))
+	ctx.Temperature = 5
+	assert.False(t, out.CheckCondition(&ctx))
+
+	err := out.EditCondition("900..1700", "lab,office", "", "..8")
+	assert.Nil(t, err)
+	assert.Equal(t, "time in 900..1700, id in {lab,office}, temperature in [..8]", out.Policy.String())
+
+	ctx = RedeemContext{Time: 1000, ID: []byte("office"), GPS: lab, Temperature: 5}
+	assert.True(t, out.CheckCondition(&ctx))
+	ctx.Time = 1800
+	assert.False(t, out.CheckCondition(&ctx))
+	ctx.Time = 1000
+	ctx.ID = []byte("garage")
+	assert.False(t, out.CheckCondition(&ctx))
+	ctx.ID = []byte("lab")
+	ctx.GPS = Coordinate{31230417, 121473701}
+	assert.False(t, out.CheckCondition(&ctx))
+
+	// a negative accuracy does not widen a geofence
+	err = out.EditCondition("", "", "31.230416,121.473701,500", "")
+	assert.Nil(t, err)
+	ctx.GPS = Coordinate{31240416, 121473701}
+	assert.False(t, out.CheckCondition(&ctx))
+	ctx.Accuracy = -2000
+	assert.False(t, out.CheckCondition(&ctx))
+	ctx.GPS, ctx.Accuracy = lab, 0
+	assert.True(t, out.CheckCondition(&ctx))
+
+	err = out.EditCondition("", "", "", "37")
+	assert.Nil(t, err)
+	assert.Nil(t, out.Policy.Temperature)
+	assert.Equal(t, 37, out.Temperature)
+}
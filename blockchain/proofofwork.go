@@ -3,86 +3,274 @@ package blockchain
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"github.com/boltdb/bolt"
 	"github.com/zhuaiballl/Go-Tokoin/utils"
 	"math"
 	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	maxNonce = math.MaxInt64
 )
 
-const targetBits = 16
+// cancelCheckInterval is the number of hashes a mining worker computes
+// between checks for a solution found by another worker or a cancellation
+const cancelCheckInterval = 1 << 12
+
+// The difficulty is the number of leading zero bits a block hash must
+// have. It starts at initialBits and is retargeted every retargetInterval
+// blocks, by one bit, when the blocks came more than twice as fast or as
+// slow as one every targetSpacing seconds.
+const (
+	initialBits      = 16
+	minBits          = 8
+	maxBits          = 64
+	retargetInterval = 10
+	targetSpacing    = 10
+)
+
+// A block must come after the median time of the medianTimeSpan blocks
+// before it, and no more than maxFutureTime seconds after the local clock
+const (
+	medianTimeSpan = 11
+	maxFutureTime  = 2 * 60 * 60
+)
 
 // ProofOfWork represents a proof-of-work
 type ProofOfWork struct {
 	block  *Block
 	target *big.Int
+	// header is the data hashed with the nonce, computed once
+	header []byte
+
+	hashes  int64
+	elapsed time.Duration
 }
 
-// NewProofOfWork builds and returns a ProofOfWork
+// NewProofOfWork builds and returns a ProofOfWork for the difficulty bits of the block
 func NewProofOfWork(b *Block) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target.Lsh(target, uint(256-b.Bits))
 
-	pow := &ProofOfWork{b, target}
+	header := bytes.Join(
+		[][]byte{
+			b.PrevBlockHash,
+			b.HashTransactions(),
+			utils.IntToHex(b.Timestamp),
+			utils.IntToHex(int64(b.Bits)),
+		},
+		[]byte{},
+	)
+	pow := &ProofOfWork{b, target, header, 0, 0}
 
 	return pow
 }
 
+// Work returns the expected number of hashes needed to meet the target,
+// a block without proof-of-work counts as one
+func (pow *ProofOfWork) Work() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(pow.block.Bits))
+}
+
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
-			utils.IntToHex(pow.block.Timestamp),
-			utils.IntToHex(int64(targetBits)),
-			utils.IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
+	data := make([]byte, len(pow.header)+8)
+	copy(data, pow.header)
+	binary.BigEndian.PutUint64(data[len(pow.header):], uint64(nonce))
 
 	return data
 }
 
 // Run performs a proof-of-work
 func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
+	nonce, hash, _ := pow.Mine(nil)
+
+	return nonce, hash
+}
+
+// Mine performs a proof-of-work on every CPU, it gives up and returns
+// false once cancel is closed
+func (pow *ProofOfWork) Mine(cancel <-chan struct{}) (int, []byte, bool) {
+	var mu sync.Mutex
+	var nonce int
+	var hash []byte
+	var stop int32
+
+	stopped := func() bool {
+		select {
+		case <-cancel:
+			return true
+		default:
+			return atomic.LoadInt32(&stop) == 1
+		}
+	}
 
 	fmt.Printf("Mining a new block")
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
+	pow.hashes = 0
+	start := time.Now()
+	done := make(chan struct{})
+	go pow.reportHashrate(start, done)
+
+	utils.ParallelFor(maxNonce, func(p *utils.Parallel) {
+		var hashInt big.Int
+		data := pow.prepareData(0)
+		counter := data[len(pow.header):]
+
+		// the hashes are added to the total every cancelCheckInterval
+		count := int64(0)
+		defer func() { atomic.AddInt64(&pow.hashes, count) }()
+
+		for n, ok := p.Next(); ok; n, ok = p.Next() {
+			if count == 0 && stopped() {
+				return
+			}
+
+			binary.BigEndian.PutUint64(counter, uint64(n))
+			h := sha256.Sum256(data)
+			hashInt.SetBytes(h[:])
+			count++
+			if count == cancelCheckInterval {
+				atomic.AddInt64(&pow.hashes, count)
+				count = 0
+			}
+
+			if hashInt.Cmp(pow.target) == -1 {
+				mu.Lock()
+				if hash == nil {
+					nonce, hash = n, h[:]
+				}
+				mu.Unlock()
+				atomic.StoreInt32(&stop, 1)
+				return
+			}
+		}
+	})
+
+	pow.elapsed = time.Since(start)
+	close(done)
+	if hash == nil {
+		fmt.Printf("\rMining was cancelled after %d hashes\n\n", pow.hashes)
+		return 0, nil, false
+	}
+	fmt.Printf("\r%x\n", hash)
+	fmt.Printf("%d hashes in %s, %.0f hashes/s\n\n", pow.hashes, pow.elapsed.Round(time.Millisecond), pow.Hashrate())
+
+	return nonce, hash, true
+}
+
+// reportHashrate prints the hashrate every second until done is closed
+func (pow *ProofOfWork) reportHashrate(start time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-		hash = sha256.Sum256(data)
-		if math.Remainder(float64(nonce), 100000) == 0 {
-			fmt.Printf("\r%x", hash)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			hashes := atomic.LoadInt64(&pow.hashes)
+			fmt.Printf("\rMining: %.0f hashes/s", float64(hashes)/time.Since(start).Seconds())
 		}
-		hashInt.SetBytes(hash[:])
+	}
+}
 
-		//if hashInt.Cmp(pow.target) == -1 {
-		break
-		//} else {
-		//	nonce++
-		//}
+// Hashrate returns the hashes per second of the last Mine
+func (pow *ProofOfWork) Hashrate() float64 {
+	if pow.elapsed <= 0 {
+		return 0
 	}
-	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	return float64(pow.hashes) / pow.elapsed.Seconds()
+}
+
+// hash returns the hash of the block header with the nonce
+func (pow *ProofOfWork) hash(nonce int) []byte {
+	hash := sha256.Sum256(pow.prepareData(nonce))
+
+	return hash[:]
 }
 
-// Validate validates block's PoW
+// Validate validates block's PoW, the hash of the block must be the hash
+// of its header and meet the target
 func (pow *ProofOfWork) Validate() bool {
-	//var hashInt big.Int
-	//
-	//data := pow.prepareData(pow.block.Nonce)
-	//hash := sha256.Sum256(data)
-	//hashInt.SetBytes(hash[:])
-	//
-	//isValid := hashInt.Cmp(pow.target) == -1
-	//
-	//return isValid
-	return true
+	var hashInt big.Int
+
+	data := pow.prepareData(pow.block.Nonce)
+	hash := sha256.Sum256(data)
+	hashInt.SetBytes(hash[:])
+
+	isValid := hashInt.Cmp(pow.target) == -1 && bytes.Equal(hash[:], pow.block.Hash)
+
+	return isValid
+}
+
+// nextBits returns the difficulty bits of a block following prev,
+// b is the blocks bucket
+func nextBits(b *bolt.Bucket, prev *Block) int {
+	bits := prev.Bits
+	if bits < minBits {
+		// blocks mined before the difficulty was stored
+		bits = initialBits
+	}
+	if (prev.Height+1)%retargetInterval != 0 {
+		return bits
+	}
+
+	first := prev
+	for i := 1; i < retargetInterval && len(first.PrevBlockHash) > 0; i++ {
+		first = DeserializeBlock(b.Get(first.PrevBlockHash))
+	}
+
+	actual := prev.Timestamp - first.Timestamp
+	expected := int64(prev.Height-first.Height) * targetSpacing
+	if actual < expected/2 && bits < maxBits {
+		bits++
+	} else if actual > expected*2 && bits > minBits {
+		bits--
+	}
+
+	return bits
+}
+
+// medianTime returns the median timestamp of prev and the blocks before it,
+// b is the blocks bucket
+func medianTime(b *bolt.Bucket, prev *Block) int64 {
+	var times []int64
+
+	for block := prev; ; block = DeserializeBlock(b.Get(block.PrevBlockHash)) {
+		times = append(times, block.Timestamp)
+		if len(times) == medianTimeSpan || len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	return times[len(times)/2]
+}
+
+// nextTimestamp returns the timestamp of a block following prev made now
+func nextTimestamp(b *bolt.Bucket, prev *Block) int64 {
+	now := time.Now().Unix()
+	if median := medianTime(b, prev); now <= median {
+		return median + 1
+	}
+
+	return now
+}
+
+// checkTimestamp checks the timestamp of a block following parent
+func checkTimestamp(b *bolt.Bucket, parent, block *Block) error {
+	if median := medianTime(b, parent); block.Timestamp <= median {
+		return fmt.Errorf("block %x has time %d, not after the median time %d of the blocks before it", block.Hash, block.Timestamp, median)
+	}
+	if limit := time.Now().Unix() + maxFutureTime; block.Timestamp > limit {
+		return fmt.Errorf("block %x has time %d, too far in the future", block.Hash, block.Timestamp)
+	}
+
+	return nil
 }
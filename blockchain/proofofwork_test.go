@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestProofOfWork(t *testing.T) {
+	owner := wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	genesis := bc.Iterator().Next()
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	newIssue := func() *Transaction {
+		return NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0)
+	}
+
+	assert.Equal(t, initialBits, genesis.Bits)
+	assert.True(t, NewProofOfWork(genesis).Validate())
+
+	// a block whose hash does not meet the target is rejected
+	forged := newTestBlock(t, bc, genesis.Hash, newIssue())
+	forged.Nonce++
+	assert.False(t, NewProofOfWork(forged).Validate())
+	assert.NotNil(t, bc.AddBlock(forged))
+	forged.Nonce--
+	forged.Hash = append([]byte{}, genesis.Hash...)
+	assert.False(t, NewProofOfWork(forged).Validate())
+
+	// as is one mined with a lower difficulty than the chain requires
+	easy := NewBlock([]*Transaction{newIssue()}, genesis.Hash, 1, minBits)
+	assert.True(t, NewProofOfWork(easy).Validate())
+	assert.NotNil(t, bc.AddBlock(easy))
+	assert.Equal(t, 0, bc.GetBestHeight())
+
+	// and one that is not later than the blocks before it, or too far ahead
+	early := &Block{genesis.Timestamp, []*Transaction{newIssue()}, genesis.Hash, []byte{}, 0, initialBits, 1, nil}
+	early.mine(nil)
+	assert.NotNil(t, bc.AddBlock(early))
+	late := &Block{time.Now().Unix() + maxFutureTime + 60, []*Transaction{newIssue()}, genesis.Hash, []byte{}, 0, initialBits, 1, nil}
+	late.mine(nil)
+	assert.NotNil(t, bc.AddBlock(late))
+	assert.Equal(t, 0, bc.GetBestHeight())
+
+	block := mineTestBlock(t, bc, newIssue())
+	assert.Equal(t, initialBits, block.Bits)
+	assert.Equal(t, 1, bc.GetBestHeight())
+	assert.True(t, block.Timestamp > genesis.Timestamp)
+}
+
+func TestRetarget(t *testing.T) {
+	// retargets returns the heights at which the difficulty changes in a
+	// chain of blocks with the spacing, followed by the final difficulty
+	retargets := func(spacing int64) []int {
+		var result []int
+		prev := &Block{0, nil, nil, []byte{0}, 0, initialBits, 0, nil}
+
+		db, err := bolt.Open(filepath.Join(t.TempDir(), "retarget.db"), 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte(blocksBucket))
+			if err != nil {
+				return err
+			}
+			for height := 1; height <= 3*retargetInterval; height++ {
+				err = b.Put(prev.Hash, prev.Serialize())
+				if err != nil {
+					return err
+				}
+				next := &Block{prev.Timestamp + spacing, nil, prev.Hash, []byte{byte(height)}, 0, nextBits(b, prev), height, nil}
+				if next.Bits != prev.Bits {
+					result = append(result, height)
+				}
+				prev = next
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return append(result, prev.Bits)
+	}
+
+	// the difficulty changes by a bit at each retarget when the blocks come
+	// too fast or too slow, and stays the same when they are on time
+	assert.Equal(t, []int{retargetInterval, 2 * retargetInterval, 3 * retargetInterval, initialBits + 3}, retargets(0))
+	assert.Equal(t, []int{retargetInterval, 2 * retargetInterval, 3 * retargetInterval, initialBits - 3}, retargets(5*targetSpacing))
+	assert.Equal(t, []int{initialBits}, retargets(targetSpacing))
+}
+
+func TestParallelMining(t *testing.T) {
+	owner := wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	issue := NewCoinbaseTX(fmt.Sprintf("%s", owner.GetAddress()), "", 0, nil, Coordinate{}, 37, 0)
+
+	block := newTestBlock(t, bc, bc.tip, issue)
+	pow := NewProofOfWork(block)
+	nonce, hash, ok := pow.Mine(nil)
+	assert.True(t, ok)
+	block.Nonce, block.Hash = nonce, hash
+	assert.True(t, NewProofOfWork(block).Validate())
+	assert.True(t, pow.hashes > 0)
+	assert.True(t, pow.Hashrate() > 0)
+	assert.Nil(t, bc.AddBlock(block))
+
+	// mining stops when it is cancelled
+	cancel := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(cancel) })
+	hard := &Block{block.Timestamp + 1, []*Transaction{issue}, bc.tip, []byte{}, 0, maxBits, 2, nil}
+	_, _, ok = NewProofOfWork(hard).Mine(cancel)
+	assert.False(t, ok)
+
+	next := NewCoinbaseTX(fmt.Sprintf("%s", owner.GetAddress()), "", 0, nil, Coordinate{}, 37, 0)
+	_, err := bc.MineBlockUntil([]*Transaction{next}, cancel)
+	assert.Equal(t, errMiningCancelled, err)
+	assert.Equal(t, 1, bc.GetBestHeight())
+}
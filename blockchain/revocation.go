@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// revocationBucket lists the holders revoked by each owner,
+// keyed by the owner key hash followed by the holder key hash
+const revocationBucket = "revocations"
+
+func revocationKey(owner, holder []byte) []byte {
+	return append(append([]byte{}, owner...), holder...)
+}
+
+// HeldThrough checks whether the key holds the output or delegated it on
+func (out *TXOutput) HeldThrough(key []byte) bool {
+	if out.IsHeldWithKey(key) {
+		return true
+	}
+	for _, delegator := range out.Delegation {
+		if bytes.Equal(delegator, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signsPayload checks whether the transaction is a revocation that spends
+// no tokoin, which the owner authorises in the payload instead of the inputs
+func (tx *Transaction) signsPayload() bool {
+	return tx.Type == OpRevokeHolder && len(tx.Vin) == 0
+}
+
+// signPayload signs the transaction ID with the owner key
+func (tx *Transaction) signPayload(privKey ecdsa.PrivateKey) {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, tx.ID)
+	if err != nil {
+		log.Panic(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	tx.Payload.OwnerPubKey = publicKeyBytes(privKey)
+	tx.Payload.OwnerSignature = signature
+}
+
+// checkRevocation checks that a revoke holder transaction burns tokoins of
+// a single owner that are held through the revoked holder, if any
+func (tx *Transaction) checkRevocation(prevOuts []TXOutput) error {
+	if len(tx.Vout) != 0 {
+		return errors.New("revokeholder cannot create tokoins")
+	}
+	if len(tx.Payload.Holder) == 0 || len(tx.Payload.Owner) == 0 {
+		return errors.New("revokeholder must name the owner and the revoked holder in its payload")
+	}
+
+	for _, prev := range prevOuts {
+		if !prev.IsLockedWithKey(tx.Payload.Owner) {
+			return errors.New("revokeholder can only revoke tokoins of the owner named in its payload")
+		}
+		if !prev.HeldThrough(tx.Payload.Holder) {
+			return fmt.Errorf("revokeholder cannot revoke a tokoin that is not held by %x", tx.Payload.Holder)
+		}
+	}
+
+	return nil
+}
+
+// IsRevoked checks whether the owner has revoked the holder
+func (u URPOSet) IsRevoked(owner, holder []byte) bool {
+	revoked := false
+	db := u.Blockchain.db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		r := tx.Bucket([]byte(revocationBucket))
+		if r != nil {
+			revoked = r.Get(revocationKey(owner, holder)) != nil
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return revoked
+}
+
+// FindHeldThrough finds the unspent tokoins of the owner held through the holder
+func (u URPOSet) FindHeldThrough(owner, holder []byte) []Outpoint {
+	var outpoints []Outpoint
+
+	ops, outs := u.findIndexed(ownerIndexBucket, owner, func(out *TXOutput) bool {
+		return out.IsLockedWithKey(owner)
+	})
+	for i, out := range outs {
+		if out.HeldThrough(holder) {
+			outpoints = append(outpoints, ops[i])
+		}
+	}
+
+	return outpoints
+}
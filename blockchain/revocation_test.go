@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestRevokeHolder(t *testing.T) {
+	owner, fired, keeper, contractor := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	genesis := bc.Iterator().Next().Transactions[0]
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	firedAddress := fmt.Sprintf("%s", fired.GetAddress())
+	ownerKey, firedKey := wlt.HashPubKey(owner.PublicKey), wlt.HashPubKey(fired.PublicKey)
+
+	tokoin := NewTXOutput(0, nil, Coordinate{}, 37, ownerAddress)
+	issue := NewIssuanceTX("", []TXOutput{*tokoin, *tokoin, *tokoin})
+	mineTestBlock(t, bc, issue)
+	kept := Deposit(owner, fmt.Sprintf("%s", keeper.GetAddress()), &urpo, Outpoint{issue.ID, 1})
+	mineTestBlock(t, bc,
+		Deposit(owner, firedAddress, &urpo, Outpoint{genesis.ID, 0}),
+		Deposit(owner, firedAddress, &urpo, Outpoint{issue.ID, 0}),
+		kept)
+	held := urpo.FindHeldThrough(ownerKey, firedKey)
+	assert.Len(t, held, 2)
+	delegate := Delegate(*fired, fmt.Sprintf("%s", contractor.GetAddress()), &urpo, held[0], "100", "")
+	mineTestBlock(t, bc, delegate)
+	ctx := RedeemContext{Temperature: 37}
+	redeem := RedeemTokoin(*contractor, ownerAddress, &urpo, Outpoint{delegate.ID, 1}, &ctx)
+
+	// the revocation burns everything held by or delegated through the holder
+	revoke := RevokeHolder(*owner, ownerAddress, firedAddress, &urpo)
+	assert.Len(t, revoke.Vin, 3)
+	assert.Nil(t, bc.ValidateTransaction(revoke))
+
+	// a stranger cannot revoke the owner's holders
+	forged := RevokeHolder(*owner, ownerAddress, firedAddress, &urpo)
+	forged.Vin[0].PubKey = keeper.PublicKey
+	bc.SignTransaction(forged, keeper.PrivateKey)
+	assert.NotNil(t, bc.ValidateTransaction(forged))
+
+	// and the holder cannot be handed a tokoin again in the same block
+	_, err := bc.MineBlock([]*Transaction{revoke, Deposit(owner, firedAddress, &urpo, Outpoint{issue.ID, 2})})
+	assert.NotNil(t, err)
+	mineTestBlock(t, bc, revoke)
+	assert.True(t, urpo.IsRevoked(ownerKey, firedKey))
+	assert.Empty(t, urpo.FindHeldThrough(ownerKey, firedKey))
+	assert.NotNil(t, bc.ValidateTransaction(redeem))
+
+	// nor later, by the owner or another holder
+	assert.NotNil(t, bc.ValidateTransaction(Deposit(owner, firedAddress, &urpo, Outpoint{issue.ID, 2})))
+	transfer := Transfer(*keeper, firedAddress, &urpo, Outpoint{kept.ID, 0})
+	assert.NotNil(t, bc.ValidateTransaction(transfer))
+	assert.True(t, urpo.IsUnspent(Outpoint{kept.ID, 0}))
+
+	// nor be issued one
+	issued := NewTXOutput(0, nil, Coordinate{}, 37, ownerAddress)
+	issued.Hold([]byte(firedAddress))
+	assert.NotNil(t, bc.ValidateTransaction(NewIssuanceTX("", []TXOutput{*issued})))
+
+	// a holder that holds nothing is revoked by the owner signature alone
+	idle := wlt.NewWallet()
+	idleAddress := fmt.Sprintf("%s", idle.GetAddress())
+	assert.Panics(t, func() { RevokeHolder(*keeper, ownerAddress, idleAddress, &urpo) })
+	unspent := RevokeHolder(*owner, ownerAddress, idleAddress, &urpo)
+	assert.Empty(t, unspent.Vin)
+	assert.Equal(t, 0, bc.MissingSignatures(unspent))
+	assert.Nil(t, bc.ValidateTransaction(unspent))
+
+	// which a stranger cannot forge
+	forged = BuildRevokeHolder(ownerKey, ownerAddress, idleAddress, &urpo)
+	bc.SignTransaction(forged, keeper.PrivateKey)
+	assert.NotNil(t, bc.ValidateTransaction(forged))
+	forged.Payload.OwnerPubKey = owner.PublicKey
+	assert.NotNil(t, bc.ValidateTransaction(forged))
+
+	mineTestBlock(t, bc, unspent)
+	assert.True(t, urpo.IsRevoked(ownerKey, wlt.HashPubKey(idle.PublicKey)))
+	assert.NotNil(t, bc.ValidateTransaction(Deposit(owner, idleAddress, &urpo, Outpoint{issue.ID, 2})))
+
+	urpo.Reindex()
+	assert.True(t, urpo.IsRevoked(ownerKey, firedKey))
+	assert.False(t, urpo.IsRevoked(firedKey, ownerKey))
+}
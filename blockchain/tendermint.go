@@ -2,8 +2,8 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"encoding/gob"
-	"encoding/hex"
 	"fmt"
 	"github.com/zhuaiballl/Go-Tokoin/config"
 	"log"
@@ -11,9 +11,8 @@ import (
 	"time"
 )
 
-const total_voting_power = 4
-
-var faultNumber int
+// validators are the nodes that propose and vote, the pools count their voting power
+var validators = DefaultValidatorSet()
 var curHeight, curRound int
 var step string
 var lockedValue []byte
@@ -22,9 +21,13 @@ var validValue []byte
 var validRound int
 var tmpBlock Block
 var proposalPool = make(map[string]int)
-var prevotePool = make(map[string]int)
-var precommitPool = make(map[string]int)
-var messagePool = make(map[string]int)
+var prevotePool = make(map[string]int64)
+var precommitPool = make(map[string]int64)
+
+// precommits holds the positive precommits received for each height, round
+// and value, the commit of the block once they reach a quorum
+var precommits = make(map[string][]precommit)
+var messagePool = make(map[string]int64)
 var hashToBlock = make(map[string]Block)
 var inSchedulePropose bool
 var inSchedulePrevote bool
@@ -41,6 +44,7 @@ type proposal struct {
 	Round      int
 	BlockHash  []byte
 	ValidRound int
+	Signature  []byte
 }
 
 type prevote struct {
@@ -48,6 +52,7 @@ type prevote struct {
 	Height      int
 	ValidRound  int
 	HashedValue []byte
+	Signature   []byte
 }
 
 type precommit struct {
@@ -55,6 +60,7 @@ type precommit struct {
 	Height      int
 	Round       int
 	HashedValue []byte
+	Signature   []byte
 }
 
 func (propo *proposal) String() string {
@@ -105,8 +111,7 @@ func (preco *precommit) height_round() string {
 }
 
 func proposer(height, round int) string {
-	//return knownNodes[(height + round) % len(knownNodes)]
-	return fmt.Sprintf("localhost:300%d", (height+round)%4)
+	return validators.Proposer(height, round)
 }
 
 func getBlock() Block {
@@ -126,7 +131,6 @@ func getBlockById(id []byte) Block {
 }
 
 func initTendermint() {
-	faultNumber = 1
 	curHeight = 0
 	curRound = 0
 	lockedValue = nil
@@ -138,6 +142,82 @@ func initTendermint() {
 	inSchedulePrecommit = false
 }
 
+// tendermintEngine is Tendermint BFT, the proposer of each height and round
+// proposes a block and the validators commit it after two rounds of votes
+type tendermintEngine struct {
+	validators *ValidatorSet
+	key        *ecdsa.PrivateKey
+}
+
+// NewTendermintEngine returns the Tendermint engine run by the validators,
+// key is the key of the validator run by the node, nil if it is not one.
+// The messages of a validator without a public key are rejected.
+func NewTendermintEngine(validators *ValidatorSet, key *ecdsa.PrivateKey) ConsensusEngine {
+	return tendermintEngine{validators, key}
+}
+
+func (e tendermintEngine) Start(bc *Blockchain) {
+	validators = e.validators
+	signingKey = e.key
+	initTendermint()
+	curHeight = bc.GetBestHeight()
+	pruneVotes(curHeight)
+}
+
+func (tendermintEngine) ProposeBlock(bc *Blockchain) error {
+	if config.NodeAddress == proposer(bc.GetBestHeight(), 0) {
+		txs := mempoolBlockTxs(bc)
+		if txs == nil {
+			return nil
+		}
+
+		block, err := bc.SealBlock(txs)
+		if err != nil {
+			return err
+		}
+		SetBlock(block)
+	}
+	SetHeight(bc.GetBestHeight())
+	StartRound(0)
+
+	return nil
+}
+
+// ValidateBlock accepts only the blocks whose commit holds the precommits
+// of a quorum of validators, whichever peer relays them
+func (e tendermintEngine) ValidateBlock(bc *Blockchain, block *Block) error {
+	err := validateSealed(block)
+	if err != nil {
+		return err
+	}
+
+	return e.validators.verifyCommit(block)
+}
+
+func (tendermintEngine) HandleMessage(bc *Blockchain, command string, request []byte) bool {
+	switch command {
+	case "proposal":
+		handleProposal(request, bc)
+	case "propoBlo":
+		handlePropoBlock(request, bc)
+	case "getPropo":
+		handleGetProposal(request, bc)
+	case "prevote":
+		handlePrevote(request, bc)
+	case "precommit":
+		handlePrecommit(request, bc)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Finalize adds a block committed by the validators, it is never reorganized away
+func (tendermintEngine) Finalize(bc *Blockchain, block *Block) error {
+	return commitBlock(bc, block)
+}
+
 func broadcastPropoBlock(b *Block) {
 	data := BlockPayload{config.NodeAddress, b.Serialize()}
 	payload := GobEncode(data)
@@ -145,9 +225,8 @@ func broadcastPropoBlock(b *Block) {
 
 	fmt.Println("broadcasting proposal block")
 
-	for i := 0; i < 4; i++ {
-		node := fmt.Sprintf("localhost:300%d", i)
-		SendData(node, request)
+	for _, v := range validators.Validators {
+		SendData(v.Address, request)
 	}
 }
 
@@ -200,7 +279,8 @@ func handleGetProposal(request []byte, bc *Blockchain) {
 }
 
 func sendProposal(addr string, hash []byte) {
-	proposal := proposal{config.NodeAddress, curHeight, curRound, hash, validRound}
+	proposal := proposal{config.NodeAddress, curHeight, curRound, hash, validRound, nil}
+	proposal.Signature = signMessage("proposal", proposal.AddrFrom, proposal.String())
 	payload := GobEncode(proposal)
 	request := append(CommandToBytes("proposal"), payload...)
 
@@ -231,10 +311,14 @@ func handleProposal(request []byte, bc *Blockchain) {
 		fmt.Println("Proposal on wrong height!")
 		return
 	}
+	// checking signature and duplicates
+	if !acceptMessage("proposal", payload.Height, payload.Round, payload.AddrFrom, payload.BlockHash, payload.String(), payload.Signature) {
+		return
+	}
 	// counting
 	proposalPool[payload.String()] = 1
 	proposalPool[payload.height_round_value()] = 1
-	add_height_and_round(payload.Height, payload.Round)
+	add_height_and_round(payload.Height, payload.Round, payload.AddrFrom)
 	// triggering rule 1
 	if payload.Height == curHeight && payload.Round == curRound && payload.ValidRound == -1 && step == "propose" {
 		voteBlock := getBlockById(payload.BlockHash)
@@ -251,15 +335,15 @@ func handleProposal(request []byte, bc *Blockchain) {
 }
 
 func broadcastPrevote(height, round int, hashedValue []byte) {
-	prevote := prevote{config.NodeAddress, height, round, hashedValue}
+	prevote := prevote{config.NodeAddress, height, round, hashedValue, nil}
+	prevote.Signature = signMessage("prevote", prevote.AddrFrom, prevote.String())
 	payload := GobEncode(prevote)
 	request := append(CommandToBytes("prevote"), payload...)
 
 	fmt.Println("broadcasting prevote message")
 
-	for i := 0; i < 4; i++ {
-		node := fmt.Sprintf("localhost:300%d", i)
-		SendData(node, request)
+	for _, v := range validators.Validators {
+		SendData(v.Address, request)
 	}
 }
 
@@ -278,6 +362,10 @@ func handlePrevote(request []byte, bc *Blockchain) {
 		fmt.Println("Prevote on wrong height!")
 		return
 	}
+	// checking signature and duplicates
+	if !acceptMessage("prevote", payload.Height, payload.ValidRound, payload.AddrFrom, payload.HashedValue, payload.String(), payload.Signature) {
+		return
+	}
 	// logging
 	status := "negative"
 	if payload.HashedValue != nil {
@@ -287,18 +375,14 @@ func handlePrevote(request []byte, bc *Blockchain) {
 
 	payloadString := payload.String()
 	// counting
-	if cnt, fd := prevotePool[payloadString]; fd {
-		prevotePool[payloadString] = cnt + 1
-	} else {
-		prevotePool[payloadString] = 1
-	}
-	add_height_and_round(payload.Height, payload.ValidRound)
+	prevotePool[payloadString] += validators.Power(payload.AddrFrom)
+	add_height_and_round(payload.Height, payload.ValidRound, payload.AddrFrom)
 
-	if prevotePool[payloadString] >= 2*faultNumber+1 {
+	if prevotePool[payloadString] >= validators.Quorum() {
 		if payload.HashedValue != nil {
 			voteBlock := getBlockById(payload.HashedValue)
 
-			targetProposal := proposal{"", curHeight, curRound, payload.HashedValue, payload.ValidRound}
+			targetProposal := proposal{"", curHeight, curRound, payload.HashedValue, payload.ValidRound, nil}
 			_, fd := proposalPool[targetProposal.String()]
 			fmt.Printf("finding %s in proposal pool\n", targetProposal.String())
 			if fd {
@@ -343,32 +427,28 @@ func handlePrevote(request []byte, bc *Blockchain) {
 	}
 
 	height_round := payload.height_round()
-	if cnt, fd := prevotePool[height_round]; fd {
-		prevotePool[height_round] = cnt + 1
-	} else {
-		prevotePool[height_round] = 1
-	}
-	if prevotePool[height_round] >= 2*faultNumber+1 && step == "prevote" && payload.Height == curHeight {
+	prevotePool[height_round] += validators.Power(payload.AddrFrom)
+	if prevotePool[height_round] >= validators.Quorum() && step == "prevote" && payload.Height == curHeight {
 		scheduleTimeoutPrevote()
 	}
 }
 
 func broadcastPrecommit(height, round int, hashedValue []byte) {
-	precommit := precommit{config.NodeAddress, height, round, hashedValue}
+	precommit := precommit{config.NodeAddress, height, round, hashedValue, nil}
+	precommit.Signature = signMessage("precommit", precommit.AddrFrom, precommit.String())
 	payload := GobEncode(precommit)
 	request := append(CommandToBytes("precommit"), payload...)
 
 	fmt.Println("broadcasting precommit message")
 
-	for i := 0; i < 4; i++ {
-		node := fmt.Sprintf("localhost:300%d", i)
-		SendData(node, request)
+	for _, v := range validators.Validators {
+		SendData(v.Address, request)
 	}
 }
 
 func handlePrecommit(request []byte, bc *Blockchain) {
 	var buff bytes.Buffer
-	var payload prevote
+	var payload precommit
 
 	buff.Write(request[config.CommandLength:])
 	dec := gob.NewDecoder(&buff)
@@ -381,6 +461,10 @@ func handlePrecommit(request []byte, bc *Blockchain) {
 		fmt.Println("Precommit on wrong height!")
 		return
 	}
+	// checking signature and duplicates
+	if !acceptMessage("precommit", payload.Height, payload.Round, payload.AddrFrom, payload.HashedValue, payload.String(), payload.Signature) {
+		return
+	}
 	// logging
 	status := "negative"
 
@@ -392,53 +476,42 @@ func handlePrecommit(request []byte, bc *Blockchain) {
 	if payload.HashedValue != nil {
 		//counting
 		payloadString := payload.String()
-		if cnt, fd := precommitPool[payloadString]; fd {
-			precommitPool[payloadString] = cnt + 1
-		} else {
-			precommitPool[payloadString] = 1
-		}
+		precommitPool[payloadString] += validators.Power(payload.AddrFrom)
+		precommits[payloadString] = append(precommits[payloadString], payload)
 
-		targetProposal := proposal{"", curHeight, payload.ValidRound, payload.HashedValue, -1}
+		targetProposal := proposal{"", curHeight, payload.Round, payload.HashedValue, -1, nil}
 		_, fd := proposalPool[targetProposal.height_round_value()]
-		if fd && precommitPool[payloadString] >= 2*faultNumber+1 && bc.GetBestHeight() <= curHeight && payload.Height == curHeight {
+		if fd && precommitPool[payloadString] >= validators.Quorum() && bc.GetBestHeight() <= curHeight && payload.Height == curHeight {
 			curHeight++
 			voteBlock := getBlockById(payload.HashedValue)
-			if bc.VerifyBlock(&voteBlock) {
+			voteBlock.Commit = precommits[payloadString]
+			if bc.VerifyBlock(&voteBlock) && commitBlock(bc, &voteBlock) == nil {
 				fmt.Printf("added a new block! current height is %d, payload height is %d\n", curHeight, payload.Height)
 				//curHeight++
-				bc.AddBlock(&voteBlock)
-				URPOSet := URPOSet{bc}
-				URPOSet.Reindex()
-				for _, tx := range voteBlock.Transactions {
-					txID := hex.EncodeToString(tx.ID)
-					DeleteMempoolTx(txID)
-				}
 				lockedRound = -1
 				lockedValue = nil
 				validRound = -1
 				validValue = nil
 				curRound = 0 //startRound(0)
+				pruneVotes(curHeight)
+				precommits = make(map[string][]precommit)
 			} else {
 				curHeight--
 			}
 		}
 	}
 
-	add_height_and_round(payload.Height, payload.ValidRound)
+	add_height_and_round(payload.Height, payload.Round, payload.AddrFrom)
 
 	height_round := payload.height_round()
-	if cnt, fd := precommitPool[height_round]; fd {
-		precommitPool[height_round] = cnt + 1
-	} else {
-		precommitPool[height_round] = 1
-	}
-	if precommitPool[height_round] >= 2*faultNumber+1 && payload.Height == curHeight {
+	precommitPool[height_round] += validators.Power(payload.AddrFrom)
+	if precommitPool[height_round] >= validators.Quorum() && payload.Height == curHeight {
 		scheduleTimeoutPrecommit()
 	}
 }
 
 func StartRound(round int) {
-	if round >= total_voting_power {
+	if round >= len(validators.Validators) {
 		return
 	}
 	fmt.Printf("start round %d...\n", round)
@@ -515,14 +588,10 @@ func onTimeoutPrecommit(height, round int) {
 	inSchedulePrecommit = false
 }
 
-func add_height_and_round(height, round int) {
+func add_height_and_round(height, round int, addrFrom string) {
 	heightnround := fmt.Sprintf("height:%d,round:%d", height, round)
-	if cnt, fd := messagePool[heightnround]; fd {
-		messagePool[heightnround] = cnt + 1
-	} else {
-		messagePool[heightnround] = 1
-	}
-	if round > curRound && messagePool[heightnround] >= faultNumber+1 {
+	messagePool[heightnround] += validators.Power(addrFrom)
+	if round > curRound && messagePool[heightnround] >= validators.OneThird() {
 		StartRound(round)
 	}
 }
@@ -0,0 +1,156 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// signingKey is the key of the validator run by the node, nil if it is not one
+var signingKey *ecdsa.PrivateKey
+
+// votes maps each height, then each step, round and validator, to the value
+// it sent, so that a message is counted once and a conflicting one is rejected
+var votes = make(map[int]map[string][]byte)
+var votesMu sync.Mutex
+
+// messageDigest is what the sender of a consensus message signs, the kind
+// of message and the sender are covered so that it cannot be replayed as
+// another message or by another validator
+func messageDigest(kind, addrFrom, body string) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s", kind, addrFrom, body)))
+
+	return digest[:]
+}
+
+// signMessage signs a consensus message with the key of the node,
+// it returns nil if the node has no key
+func signMessage(kind, addrFrom, body string) []byte {
+	if signingKey == nil {
+		return nil
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, signingKey, messageDigest(kind, addrFrom, body))
+	if err != nil {
+		log.Panic(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signature
+}
+
+// verifyMessage checks that a consensus message is signed by a validator
+func verifyMessage(kind, addrFrom, body string, signature []byte) error {
+	return validators.verify(kind, addrFrom, body, signature)
+}
+
+// verify checks that a consensus message is signed by a validator of the set
+func (set *ValidatorSet) verify(kind, addrFrom, body string, signature []byte) error {
+	v := set.Get(addrFrom)
+	if v == nil {
+		return fmt.Errorf("%s is not a validator", addrFrom)
+	}
+	if v.PubKey == "" {
+		return fmt.Errorf("validator %s has no public key", addrFrom)
+	}
+
+	pubKey, err := hex.DecodeString(v.PubKey)
+	if err != nil {
+		return err
+	}
+	if len(signature) != 64 || !verifySignature(pubKey, signature, messageDigest(kind, addrFrom, body)) {
+		return fmt.Errorf("the %s from %s has an invalid signature", kind, addrFrom)
+	}
+
+	return nil
+}
+
+// verifyCommit checks that the commit of the block holds precommits of it
+// in one round by validators with more than 2/3 of the voting power. The
+// validators vote on a block at the height of its parent.
+func (set *ValidatorSet) verifyCommit(block *Block) error {
+	var power int64
+	seen := make(map[string]bool)
+	for _, p := range block.Commit {
+		if p.Height != block.Height-1 || p.Round != block.Commit[0].Round || !bytes.Equal(p.HashedValue, block.Hash) {
+			return fmt.Errorf("block %x has a precommit of another block", block.Hash)
+		}
+		if seen[p.AddrFrom] {
+			return fmt.Errorf("block %x has two precommits of %s", block.Hash, p.AddrFrom)
+		}
+		seen[p.AddrFrom] = true
+
+		err := set.verify("precommit", p.AddrFrom, p.String(), p.Signature)
+		if err != nil {
+			return err
+		}
+		power += set.Power(p.AddrFrom)
+	}
+	if power < set.Quorum() {
+		return fmt.Errorf("block %x is not committed by a quorum of validators", block.Hash)
+	}
+
+	return nil
+}
+
+// recordVote records the value the validator sent at the step, height and
+// round. It returns false if the validator already sent it, and an error if
+// the validator sent another value, which is an equivocation.
+func recordVote(kind string, height, round int, addrFrom string, value []byte) (bool, error) {
+	votesMu.Lock()
+	defer votesMu.Unlock()
+
+	if votes[height] == nil {
+		votes[height] = make(map[string][]byte)
+	}
+	key := fmt.Sprintf("%s/%d/%s", kind, round, addrFrom)
+	if prev, ok := votes[height][key]; ok {
+		if bytes.Equal(prev, value) {
+			return false, nil
+		}
+		return false, fmt.Errorf("equivocation by %s: %s of %x and of %x at height %d, round %d", addrFrom, kind, prev, value, height, round)
+	}
+	votes[height][key] = value
+
+	return true, nil
+}
+
+// pruneVotes forgets the votes below the height, which is no longer voted on
+func pruneVotes(height int) {
+	votesMu.Lock()
+	defer votesMu.Unlock()
+
+	for h := range votes {
+		if h < height {
+			delete(votes, h)
+		}
+	}
+}
+
+// acceptMessage checks the signature of a consensus message and whether
+// it is the first one of its validator at the step, height and round
+func acceptMessage(kind string, height, round int, addrFrom string, value []byte, body string, signature []byte) bool {
+	err := verifyMessage(kind, addrFrom, body, signature)
+	if err != nil {
+		fmt.Printf("Rejected %s: %s\n", kind, err)
+		return false
+	}
+
+	isNew, err := recordVote(kind, height, round, addrFrom, value)
+	if err != nil {
+		fmt.Printf("Rejected %s: %s\n", kind, err)
+		return false
+	}
+	if !isNew {
+		fmt.Printf("Ignored duplicate %s from %s\n", kind, addrFrom)
+	}
+
+	return isNew
+}
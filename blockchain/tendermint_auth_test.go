@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// useValidators runs the test with the validator set and signing key
+func useValidators(t *testing.T, set *ValidatorSet, key *wlt.Wallet) {
+	prevValidators, prevKey, prevVotes := validators, signingKey, votes
+	t.Cleanup(func() {
+		validators, signingKey, votes = prevValidators, prevKey, prevVotes
+	})
+
+	validators, signingKey, votes = set, nil, make(map[int]map[string][]byte)
+	if key != nil {
+		signingKey = &key.PrivateKey
+	}
+}
+
+func TestSignedConsensusMessages(t *testing.T) {
+	a, b := wlt.NewWallet(), wlt.NewWallet()
+	set := &ValidatorSet{[]Validator{
+		{"localhost:3000", hex.EncodeToString(a.PublicKey), 2},
+		{"localhost:3001", hex.EncodeToString(b.PublicKey), 1},
+	}}
+	useValidators(t, set, a)
+
+	vote := prevote{"localhost:3000", 1, 0, []byte("block"), nil}
+	vote.Signature = signMessage("prevote", vote.AddrFrom, vote.String())
+	assert.Nil(t, verifyMessage("prevote", vote.AddrFrom, vote.String(), vote.Signature))
+
+	// the signature covers the value, the kind of message and the sender
+	forged := vote
+	forged.HashedValue = []byte("other")
+	assert.NotNil(t, verifyMessage("prevote", forged.AddrFrom, forged.String(), forged.Signature))
+	assert.NotNil(t, verifyMessage("precommit", vote.AddrFrom, vote.String(), vote.Signature))
+	assert.NotNil(t, verifyMessage("prevote", "localhost:3001", vote.String(), vote.Signature))
+	assert.NotNil(t, verifyMessage("prevote", vote.AddrFrom, vote.String(), nil))
+	assert.NotNil(t, verifyMessage("prevote", "localhost:3002", vote.String(), vote.Signature))
+
+	// each validator is counted once per step, height and round
+	assert.True(t, acceptMessage("prevote", vote.Height, vote.ValidRound, vote.AddrFrom, vote.HashedValue, vote.String(), vote.Signature))
+	assert.False(t, acceptMessage("prevote", vote.Height, vote.ValidRound, vote.AddrFrom, vote.HashedValue, vote.String(), vote.Signature))
+	commit := precommit{"localhost:3000", 1, 0, []byte("block"), nil}
+	commit.Signature = signMessage("precommit", commit.AddrFrom, commit.String())
+	assert.True(t, acceptMessage("precommit", commit.Height, commit.Round, commit.AddrFrom, commit.HashedValue, commit.String(), commit.Signature))
+
+	// and a conflicting vote is rejected as an equivocation
+	other := prevote{"localhost:3000", 1, 0, nil, nil}
+	other.Signature = signMessage("prevote", other.AddrFrom, other.String())
+	assert.False(t, acceptMessage("prevote", other.Height, other.ValidRound, other.AddrFrom, other.HashedValue, other.String(), other.Signature))
+	_, err := recordVote("prevote", 1, 0, "localhost:3000", nil)
+	assert.NotNil(t, err)
+	isNew, err := recordVote("prevote", 1, 1, "localhost:3000", nil)
+	assert.True(t, isNew)
+	assert.Nil(t, err)
+
+	// the votes below a committed height are forgotten
+	later := prevote{"localhost:3000", 2, 0, []byte("block"), nil}
+	later.Signature = signMessage("prevote", later.AddrFrom, later.String())
+	assert.True(t, acceptMessage("prevote", later.Height, later.ValidRound, later.AddrFrom, later.HashedValue, later.String(), later.Signature))
+	pruneVotes(2)
+	assert.NotContains(t, votes, 1)
+	assert.Contains(t, votes, 2)
+
+	// validators without keys are not trusted
+	useValidators(t, DefaultValidatorSet(), nil)
+	assert.Nil(t, signMessage("prevote", vote.AddrFrom, vote.String()))
+	assert.NotNil(t, verifyMessage("prevote", vote.AddrFrom, vote.String(), nil))
+	assert.NotNil(t, verifyMessage("prevote", "localhost:4000", vote.String(), nil))
+}
+
+func TestValidatorSigningKey(t *testing.T) {
+	a := wlt.NewWallet()
+	set := &ValidatorSet{[]Validator{
+		{"localhost:3000", hex.EncodeToString(a.PublicKey), 1},
+		{"localhost:3001", "", 1},
+	}}
+	wallets := &wlt.Wallets{Wallets: map[string]*wlt.Wallet{string(a.GetAddress()): a}}
+
+	key, err := set.SigningKey("localhost:3000", wallets)
+	assert.Nil(t, err)
+	assert.Equal(t, a.PrivateKey.D, key.D)
+
+	key, err = set.SigningKey("localhost:3001", wallets)
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+	key, err = set.SigningKey("localhost:3002", wallets)
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+
+	_, err = set.SigningKey("localhost:3000", &wlt.Wallets{Wallets: map[string]*wlt.Wallet{}})
+	assert.NotNil(t, err)
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/zhuaiballl/Go-Tokoin/utils"
 	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
 	"math/big"
-	"strconv"
 	"strings"
 
 	"encoding/gob"
@@ -22,9 +21,11 @@ const subsidy = 10
 
 // Transaction represents a Bitcoin transaction
 type Transaction struct {
-	ID   []byte
-	Vin  []TXInput
-	Vout []TXOutput
+	ID      []byte
+	Type    OpType
+	Vin     []TXInput
+	Vout    []TXOutput
+	Payload OpPayload
 }
 
 // IsCoinbase checks whether the transaction is coinbase
@@ -45,11 +46,15 @@ func (tx Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
-// Hash returns the hash of the Transaction
+// Hash returns the hash of the Transaction, the signatures are left out
+// as they sign the ID
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *tx
+	if !tx.IsCoinbase() {
+		txCopy = tx.TrimmedCopy()
+	}
 	txCopy.ID = []byte{}
 
 	hash = sha256.Sum256(txCopy.Serialize())
@@ -62,6 +67,10 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 	if tx.IsCoinbase() {
 		return
 	}
+	if tx.signsPayload() {
+		tx.signPayload(privKey)
+		return
+	}
 
 	for _, vin := range tx.Vin {
 		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
@@ -70,21 +79,32 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 	}
 
 	txCopy := tx.TrimmedCopy()
+	role := tx.Type.SignerRole()
 
 	for inID, vin := range txCopy.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		prevOut := prevTx.Vout[vin.Vout]
 		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		txCopy.Vin[inID].PubKey = prevOut.KeyForRole(role)
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+		dataToSign := sha256.Sum256([]byte(fmt.Sprintf("%x\n", txCopy)))
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		r, s, err := ecdsa.Sign(rand.Reader, &privKey, dataToSign[:])
 		if err != nil {
 			log.Panic(err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
-
-		tx.Vin[inID].Signature = signature
+		// r and s are padded to a fixed width so that Verify can split them
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+
+		// each owner of a multi-signature tokoin adds a witness
+		if usesWitnesses(role, &prevOut) {
+			tx.Vin[inID].addWitness(Witness{publicKeyBytes(privKey), signature})
+		} else {
+			tx.Vin[inID].Signature = signature
+			tx.Vin[inID].PubKey = publicKeyBytes(privKey)
+		}
 		txCopy.Vin[inID].PubKey = nil
 	}
 }
@@ -94,6 +114,8 @@ func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	lines = append(lines, fmt.Sprintf("     Type:      %s", tx.Type))
+	lines = append(lines, fmt.Sprintf("     Payload:   %s", tx.Payload))
 
 	for i, input := range tx.Vin {
 
@@ -108,10 +130,15 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Time:         %d", output.Time))
 		lines = append(lines, fmt.Sprintf("       ID:           %s", output.ID))
-		lines = append(lines, fmt.Sprintf("       GPS:          %d", output.GPS))
+		lines = append(lines, fmt.Sprintf("       GPS:          %s", output.GPS))
 		lines = append(lines, fmt.Sprintf("       Temperature:  %d", output.Temperature))
 		lines = append(lines, fmt.Sprintf("       OwnerKey:     %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Owners:       %s", output.owners()))
 		lines = append(lines, fmt.Sprintf("       HolderKey:    %x", output.HolderKey))
+		lines = append(lines, fmt.Sprintf("       Policy:       %s", output.Policy))
+		lines = append(lines, fmt.Sprintf("       Expiry:       %s", output.expiry()))
+		lines = append(lines, fmt.Sprintf("       Uses:         %s", output.uses()))
+		lines = append(lines, fmt.Sprintf("       Delegation:   %s", output.delegation()))
 	}
 
 	return strings.Join(lines, "\n")
@@ -123,14 +150,16 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil, nil})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Time, vout.ID, vout.GPS, vout.Temperature, vout.PubKeyHash, vout.HolderKey})
+		outputs = append(outputs, vout)
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	payload := tx.Payload
+	payload.OwnerPubKey, payload.OwnerSignature = nil, nil
+	txCopy := Transaction{tx.ID, tx.Type, inputs, outputs, payload}
 
 	return txCopy
 }
@@ -140,6 +169,9 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
+	if tx.signsPayload() {
+		return verifySignature(tx.Payload.OwnerPubKey, tx.Payload.OwnerSignature, tx.ID)
+	}
 
 	for _, vin := range tx.Vin {
 		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
@@ -148,39 +180,105 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	}
 
 	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	role := tx.Type.SignerRole()
 
 	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		prevOut := prevTx.Vout[vin.Vout]
 		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		txCopy.Vin[inID].PubKey = prevOut.KeyForRole(role)
+
+		dataToVerify := sha256.Sum256([]byte(fmt.Sprintf("%x\n", txCopy)))
+
+		if usesWitnesses(role, &prevOut) {
+			for _, w := range vin.Witnesses {
+				if !verifySignature(w.PubKey, w.Signature, dataToVerify[:]) {
+					return false
+				}
+			}
+		} else if !verifySignature(vin.PubKey, vin.Signature, dataToVerify[:]) {
+			return false
+		}
+		txCopy.Vin[inID].PubKey = nil
+	}
+
+	return true
+}
 
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
+func verifySignature(pubKey, signature, data []byte) bool {
+	if len(pubKey) == 0 || len(signature) == 0 {
+		return false
+	}
+	curve := elliptic.P256()
 
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+
+	rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+	return ecdsa.Verify(&rawPubKey, data, &r, &s)
+}
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+// VerifySigners checks that every input is signed by the party the operation
+// requires, i.e. the owner or the holder of the output it spends
+func (tx *Transaction) VerifySigners(prevTXs map[string]Transaction) bool {
+	if tx.IsCoinbase() {
+		return tx.Type == OpCreate
+	}
+
+	if tx.signsPayload() {
+		return bytes.Equal(wlt.HashPubKey(tx.Payload.OwnerPubKey), tx.Payload.Owner)
+	}
+
+	role := tx.Type.SignerRole()
+	if role == RoleNone {
+		return false
+	}
+
+	for _, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false
+		}
+		prevOut := prevTx.Vout[vin.Vout]
+
+		if usesWitnesses(role, &prevOut) {
+			if vin.ownerSignatures(&prevOut) < prevOut.Threshold {
+				return false
+			}
+			continue
+		}
+
+		key := prevOut.KeyForRole(role)
+		if len(key) == 0 || !vin.UsesKey(key) {
 			return false
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
 	return true
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(addr, data string, time int, id []byte, gps int, temper int) *Transaction {
+// NewCoinbaseTX creates a new coinbase transaction,
+// a positive number of uses makes the tokoin metered
+func NewCoinbaseTX(addr, data string, time int, id []byte, gps Coordinate, temper int, uses int) *Transaction {
+	txout := NewTXOutput(time, id, gps, temper, addr)
+	if uses > 0 {
+		txout.Meter(uses)
+	}
+
+	return NewIssuanceTX(data, []TXOutput{*txout})
+}
+
+// NewIssuanceTX creates a coinbase transaction minting all the given tokoins at once
+func NewIssuanceTX(data string, outputs []TXOutput) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -191,9 +289,8 @@ func NewCoinbaseTX(addr, data string, time int, id []byte, gps int, temper int)
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(time, id, gps, temper, addr)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	txin := TXInput{[]byte{}, -1, nil, []byte(data), nil}
+	tx := Transaction{nil, OpCreate, []TXInput{txin}, outputs, OpPayload{}}
 	tx.ID = tx.Hash()
 
 	return &tx
@@ -207,7 +304,6 @@ func NewURPOTransaction(wallet *wlt.Wallet, to string, URPOSet *URPOSet) *Transa
 	pubKeyHash := wlt.HashPubKey(wallet.PublicKey)
 	validOutputs := URPOSet.FindSpendableOutputs(pubKeyHash)
 
-	// Build a list of inputs
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
 		if err != nil {
@@ -215,15 +311,14 @@ func NewURPOTransaction(wallet *wlt.Wallet, to string, URPOSet *URPOSet) *Transa
 		}
 
 		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
+			input := TXInput{txID, out, nil, wallet.PublicKey, nil}
 			inputs = append(inputs, input)
 		}
 	}
 
-	// Build a list of outputs
-	outputs = append(outputs, *NewTXOutput(0, nil, 0, 0, to))
+	outputs = append(outputs, *NewTXOutput(0, nil, Coordinate{}, 0, to))
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, OpCreate, inputs, outputs, OpPayload{}}
 	tx.ID = tx.Hash()
 	URPOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
 
@@ -231,124 +326,281 @@ func NewURPOTransaction(wallet *wlt.Wallet, to string, URPOSet *URPOSet) *Transa
 }
 
 // Deposit sets a holder for a tokoin
-func Deposit(wallet *wlt.Wallet, holder string, URPOSet *URPOSet, txId string) *Transaction {
+func Deposit(wallet *wlt.Wallet, holder string, URPOSet *URPOSet, outpoint Outpoint) *Transaction {
+	tx := BuildDeposit(wlt.HashPubKey(wallet.PublicKey), holder, URPOSet, outpoint)
+	URPOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// BuildDeposit builds an unsigned deposit of a tokoin owned by the signer key hash
+func BuildDeposit(pubKeyHash []byte, holder string, URPOSet *URPOSet, outpoint Outpoint) *Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
-	pubKeyHash := wlt.HashPubKey(wallet.PublicKey)
-	txID, err := hex.DecodeString(txId)
+	output, err := URPOSet.FindOutput(outpoint)
 	if err != nil {
 		log.Panic(err)
 	}
-	output := URPOSet.FindOutput(txID)
-	if !(output.IsLockedWithKey(pubKeyHash)) {
+	if !(output.IsOwnedBy(pubKeyHash)) {
 		log.Panic("ERROR: Not locked with this key")
 	}
 
-	input := TXInput{txID, 0, nil, wallet.PublicKey}
+	input := TXInput{outpoint.Txid, outpoint.Vout, nil, nil, nil}
 	inputs = append(inputs, input)
 
 	newOutput := output
 	newOutput.Hold([]byte(holder))
 	outputs = append(outputs, newOutput)
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, OpDeposit, inputs, outputs, OpPayload{Holder: newOutput.HolderKey}}
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// Transfer moves a tokoin to a new holder, it can only be done by the current holder
+func Transfer(wallet wlt.Wallet, to string, URPOSet *URPOSet, outpoint Outpoint) *Transaction {
+	tx := BuildTransfer(wlt.HashPubKey(wallet.PublicKey), to, URPOSet, outpoint)
+	URPOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// BuildTransfer builds an unsigned transfer of a tokoin held by the signer key hash
+func BuildTransfer(holderKey []byte, to string, URPOSet *URPOSet, outpoint Outpoint) *Transaction {
+	var inputs []TXInput
+	var outputs []TXOutput
+
+	output, err := URPOSet.FindOutput(outpoint)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !(output.IsHeldWithKey(holderKey)) {
+		log.Panic("ERROR: Not held with this key")
+	}
+
+	input := TXInput{outpoint.Txid, outpoint.Vout, nil, nil, nil}
+	inputs = append(inputs, input)
+
+	// Only the holder changes, the owner and the conditions are kept
+	newOutput := output
+	newOutput.Hold([]byte(to))
+	outputs = append(outputs, newOutput)
+
+	tx := Transaction{nil, OpTransfer, inputs, outputs, OpPayload{Holder: newOutput.HolderKey}}
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// Delegate lends a held tokoin to another address until the expiry,
+// the parent tokoin stays with the holder as the first output
+func Delegate(wallet wlt.Wallet, to string, URPOSet *URPOSet, outpoint Outpoint, expiryHeight, expiryTime string) *Transaction {
+	tx := BuildDelegate(wlt.HashPubKey(wallet.PublicKey), to, URPOSet, outpoint, expiryHeight, expiryTime)
+	URPOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// BuildDelegate builds an unsigned delegation of a tokoin held by the signer key hash
+func BuildDelegate(holderKey []byte, to string, URPOSet *URPOSet, outpoint Outpoint, expiryHeight, expiryTime string) *Transaction {
+	output, err := URPOSet.FindOutput(outpoint)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !(output.IsHeldWithKey(holderKey)) {
+		log.Panic("ERROR: Not held with this key")
+	}
+
+	input := TXInput{outpoint.Txid, outpoint.Vout, nil, nil, nil}
+
+	expiry := TXOutput{}
+	err = expiry.EditExpiry(expiryHeight, expiryTime)
+	if err != nil {
+		log.Panic(err)
+	}
+	delegateKey := utils.Base58Decode([]byte(to))
+	delegateKey = delegateKey[1 : len(delegateKey)-4]
+	delegated, err := output.NewDelegation(delegateKey, expiry.ExpiryHeight, expiry.ExpiryTime)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	parent := output
+	if parent.Metered {
+		parent.RemainingUses--
+	}
+
+	tx := Transaction{nil, OpDelegate, []TXInput{input}, []TXOutput{parent, *delegated}, OpPayload{Holder: delegateKey}}
 	tx.ID = tx.Hash()
-	URPOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
 
 	return &tx
 }
 
 // get a tokoin, edit it, and put the new one back in the blockchain
-func EditPolicy(wallet wlt.Wallet, URPOSet *URPOSet, txId []byte, time, id, gps, temper string) *Transaction {
+func EditPolicy(wallet wlt.Wallet, URPOSet *URPOSet, outpoint Outpoint, time, id, gps, temper, expiryHeight, expiryTime string) *Transaction {
+	tx := BuildEditPolicy(wlt.HashPubKey(wallet.PublicKey), URPOSet, outpoint, time, id, gps, temper, expiryHeight, expiryTime)
+	URPOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// BuildEditPolicy builds an unsigned edit of a tokoin owned by the signer key hash
+func BuildEditPolicy(pubKeyHash []byte, URPOSet *URPOSet, outpoint Outpoint, time, id, gps, temper, expiryHeight, expiryTime string) *Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
-	pubKeyHash := wlt.HashPubKey(wallet.PublicKey)
-	output := URPOSet.FindOutput(txId)
-	if !(output.IsLockedWithKey(pubKeyHash)) {
+	output, err := URPOSet.FindOutput(outpoint)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !(output.IsOwnedBy(pubKeyHash)) {
 		log.Panic("ERROR: Not locked with this key")
 	}
 
-	input := TXInput{txId, 0, nil, wallet.PublicKey}
+	input := TXInput{outpoint.Txid, outpoint.Vout, nil, nil, nil}
 	inputs = append(inputs, input)
 
 	// Build a list of outputs
 	//from := fmt.Sprintf("%s", wallet.GetAddress())
 	newOutput := output
 	// update if the transfered parameters are not empty
-	if time != "" {
-		newOutput.Time, _ = strconv.Atoi(time)
-	}
-	if id != "" {
-		newOutput.ID = []byte(id)
-	}
-	if gps != "" {
-		newOutput.GPS, _ = strconv.Atoi(gps)
+	err = newOutput.EditCondition(time, id, gps, temper)
+	if err != nil {
+		log.Panic(err)
 	}
-	if temper != "" {
-		newOutput.Temperature, _ = strconv.Atoi(temper)
+	err = newOutput.EditExpiry(expiryHeight, expiryTime)
+	if err != nil {
+		log.Panic(err)
 	}
 	outputs = append(outputs, newOutput)
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, OpEdit, inputs, outputs, OpPayload{}}
 	tx.ID = tx.Hash()
-	URPOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
 
 	return &tx
 }
 
-func RevocatTokoin(wallet wlt.Wallet, URPOSet *URPOSet, txId []byte) *Transaction {
+// RevocatTokoin discards a tokoin, it can only be done by the owner
+func RevocatTokoin(wallet wlt.Wallet, URPOSet *URPOSet, outpoint Outpoint) *Transaction {
+	tx := BuildRevocatTokoin(wlt.HashPubKey(wallet.PublicKey), URPOSet, outpoint)
+	URPOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// BuildRevocatTokoin builds an unsigned discard of a tokoin owned by the signer key hash
+func BuildRevocatTokoin(pubKeyHash []byte, URPOSet *URPOSet, outpoint Outpoint) *Transaction {
 	var inputs []TXInput
 
-	pubKeyHash := wlt.HashPubKey(wallet.PublicKey)
-	output := URPOSet.FindOutput(txId)
-	if !(output.IsLockedWithKey(pubKeyHash)) {
+	output, err := URPOSet.FindOutput(outpoint)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !(output.IsOwnedBy(pubKeyHash)) {
 		log.Panic("ERROR: Not locked with this key")
 	}
 
-	input := TXInput{txId, 0, nil, wallet.PublicKey}
+	input := TXInput{outpoint.Txid, outpoint.Vout, nil, nil, nil}
 	inputs = append(inputs, input)
 
-	tx := Transaction{nil, inputs, []TXOutput{}}
+	tx := Transaction{nil, OpDiscard, inputs, []TXOutput{}, OpPayload{}}
 	tx.ID = tx.Hash()
-	URPOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
 
 	return &tx
 }
 
-func RedeemTokoin(wallet wlt.Wallet, holder string, URPOSet *URPOSet, txId []byte, time *int, id *[]byte, gps, temper *int) *Transaction {
+// RevokeHolder burns all the owner's tokoins held through the holder and
+// publishes the revocation, so that the holder cannot be handed them again,
+// the revocation spends nothing when the holder holds none of them
+func RevokeHolder(wallet wlt.Wallet, owner, holder string, URPOSet *URPOSet) *Transaction {
+	tx := BuildRevokeHolder(wlt.HashPubKey(wallet.PublicKey), owner, holder, URPOSet)
+	URPOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// BuildRevokeHolder builds an unsigned revocation of the holder by an owner with the signer key hash
+func BuildRevokeHolder(pubKeyHash []byte, owner, holder string, URPOSet *URPOSet) *Transaction {
 	var inputs []TXInput
-	var outputs []TXOutput
 
-	ownerKey := wlt.HashPubKey(wallet.PublicKey)
-	output := URPOSet.FindOutput(txId)
-	if !(output.IsLockedWithKey(ownerKey)) {
-		log.Panic("ERROR: Wrong owner")
-	}
+	ownerKey := utils.Base58Decode([]byte(owner))
+	ownerKey = ownerKey[1 : len(ownerKey)-4]
 	holderKey := utils.Base58Decode([]byte(holder))
 	holderKey = holderKey[1 : len(holderKey)-4]
+
+	for _, outpoint := range URPOSet.FindHeldThrough(ownerKey, holderKey) {
+		output, err := URPOSet.FindOutput(outpoint)
+		if err != nil {
+			log.Panic(err)
+		}
+		if !(output.IsOwnedBy(pubKeyHash)) {
+			log.Panic("ERROR: Not locked with this key")
+		}
+		inputs = append(inputs, TXInput{outpoint.Txid, outpoint.Vout, nil, nil, nil})
+	}
+	// with nothing to burn the owner signs the revocation itself
+	if len(inputs) == 0 && !bytes.Equal(pubKeyHash, ownerKey) {
+		log.Panic("ERROR: Not the owner")
+	}
+
+	tx := Transaction{nil, OpRevokeHolder, inputs, []TXOutput{}, OpPayload{Holder: holderKey, Owner: ownerKey}}
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// RedeemTokoin sends a tokoin back to its owner, it can only be done by the holder
+func RedeemTokoin(wallet wlt.Wallet, owner string, URPOSet *URPOSet, outpoint Outpoint, ctx *RedeemContext) *Transaction {
+	tx := BuildRedeemTokoin(wlt.HashPubKey(wallet.PublicKey), owner, URPOSet, outpoint, ctx)
+	URPOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+// BuildRedeemTokoin builds an unsigned redemption of a tokoin held by the signer key hash
+func BuildRedeemTokoin(holderKey []byte, owner string, URPOSet *URPOSet, outpoint Outpoint, ctx *RedeemContext) *Transaction {
+	var inputs []TXInput
+	var outputs []TXOutput
+
+	output, err := URPOSet.FindOutput(outpoint)
+	if err != nil {
+		log.Panic(err)
+	}
 	if !(output.IsHeldWithKey(holderKey)) {
 		log.Panic("ERROR: Wrong holder")
 	}
+	ownerKey := utils.Base58Decode([]byte(owner))
+	ownerKey = ownerKey[1 : len(ownerKey)-4]
+	if !(output.IsLockedWithKey(ownerKey)) {
+		log.Panic("ERROR: Wrong owner")
+	}
 
 	//Check the redeem condition
-	if !(output.CheckCondition(time, id, gps, temper)) {
+	if !(output.CheckCondition(ctx)) {
 		log.Panic("ERROR: Condition not satisfied")
 	}
+	if output.Metered && output.RemainingUses <= 0 {
+		log.Panic("ERROR: No uses left")
+	}
 
-	input := TXInput{txId, 0, nil, wallet.PublicKey}
+	input := TXInput{outpoint.Txid, outpoint.Vout, nil, nil, nil}
 	inputs = append(inputs, input)
 
-	// Build a list of outputs
-	newOutput := output
-	// Remove the holderkey
-	newOutput.HolderKey = nil
-	outputs = append(outputs, newOutput)
+	// Build a list of outputs, a delegated tokoin is used up instead
+	if !output.IsDelegated() {
+		newOutput := output
+		// Remove the holderkey and use up one redemption
+		newOutput.HolderKey = nil
+		if newOutput.Metered {
+			newOutput.RemainingUses--
+		}
+		outputs = append(outputs, newOutput)
+	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, OpRedeem, inputs, outputs, OpPayload{Context: ctx}}
 	tx.ID = tx.Hash()
-	URPOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
 
 	return &tx
 }
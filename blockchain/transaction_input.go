@@ -11,6 +11,14 @@ type TXInput struct {
 	Vout      int
 	Signature []byte
 	PubKey    []byte
+	// Witnesses hold the owner signatures when spending a multi-signature tokoin
+	Witnesses []Witness
+}
+
+// Witness is the signature of one of the owners of a multi-signature tokoin
+type Witness struct {
+	PubKey    []byte
+	Signature []byte
 }
 
 // UsesKey checks whether the address initiated the transaction
@@ -19,3 +27,8 @@ func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
 
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
+
+// Outpoint returns the outpoint of the tokoin spent by the input
+func (in *TXInput) Outpoint() Outpoint {
+	return Outpoint{in.Txid, in.Vout}
+}
@@ -6,16 +6,33 @@ import (
 	"fmt"
 	"github.com/zhuaiballl/Go-Tokoin/utils"
 	"log"
+	"strconv"
+	"strings"
 )
 
 // TXOutput represents a transaction output
 type TXOutput struct {
 	Time        int
 	ID          []byte
-	GPS         int
+	GPS         Coordinate
 	Temperature int
 	PubKeyHash  []byte
 	HolderKey   []byte
+	Policy      Policy
+	// Owners and Threshold lock a multi-signature tokoin to Threshold of the
+	// Owners key hashes, PubKeyHash is then the hash of the owner set
+	Owners    [][]byte
+	Threshold int
+	// ExpiryHeight and ExpiryTime are the last block height and unix time
+	// at which the tokoin can be used, zero means it never expires
+	ExpiryHeight int
+	ExpiryTime   int64
+	// RemainingUses is the number of redemptions left for a metered tokoin
+	Metered       bool
+	RemainingUses int
+	// Delegation lists the holder keys the tokoin was delegated through,
+	// from the original holder down to the delegator
+	Delegation [][]byte
 }
 
 // Lock signs the output
@@ -42,22 +59,112 @@ func (out *TXOutput) IsHeldWithKey(holderKey []byte) bool {
 	return bytes.Compare(out.HolderKey, holderKey) == 0
 }
 
+// SameTerms checks whether two outputs only differ in their holder
+func (out *TXOutput) SameTerms(other *TXOutput) bool {
+	a, b := *out, *other
+	a.HolderKey, b.HolderKey = nil, nil
+
+	return bytes.Compare(TXOutputs{[]TXOutput{a}}.Serialize(), TXOutputs{[]TXOutput{b}}.Serialize()) == 0
+}
+
+// SameHolding checks whether two outputs only differ in their redemption
+// conditions and their expiry, which is all an edit can change
+func (out *TXOutput) SameHolding(other *TXOutput) bool {
+	a, b := *out, *other
+	for _, o := range []*TXOutput{&a, &b} {
+		o.Time, o.ID, o.GPS, o.Temperature, o.Policy = 0, nil, Coordinate{}, 0, Policy{}
+		o.ExpiryHeight, o.ExpiryTime = 0, 0
+	}
+
+	return bytes.Compare(TXOutputs{[]TXOutput{a}}.Serialize(), TXOutputs{[]TXOutput{b}}.Serialize()) == 0
+}
+
+// Meter limits the output to a number of redemptions
+func (out *TXOutput) Meter(uses int) {
+	out.Metered = true
+	out.RemainingUses = uses
+}
+
+// IsExpired checks whether the output has lapsed for a block at the given height and time
+func (out *TXOutput) IsExpired(height int, timestamp int64) bool {
+	if out.ExpiryHeight > 0 && height > out.ExpiryHeight {
+		return true
+	}
+	if out.ExpiryTime > 0 && timestamp > out.ExpiryTime {
+		return true
+	}
+
+	return false
+}
+
+// EditExpiry sets the expiry height and time from CLI specs,
+// an empty spec leaves the expiry untouched and "0" removes it
+func (out *TXOutput) EditExpiry(height, timestamp string) error {
+	if height != "" {
+		h, err := strconv.Atoi(height)
+		if err != nil || h < 0 {
+			return fmt.Errorf("invalid expiry height %q", height)
+		}
+		out.ExpiryHeight = h
+	}
+	if timestamp != "" {
+		t, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || t < 0 {
+			return fmt.Errorf("invalid expiry time %q", timestamp)
+		}
+		out.ExpiryTime = t
+	}
+
+	return nil
+}
+
 // NewTXOutput create a new TXOutput
-func NewTXOutput(time int, id []byte, gps int, temper int, address string) *TXOutput {
-	txo := &TXOutput{time, id, gps, temper, nil, nil}
+func NewTXOutput(time int, id []byte, gps Coordinate, temper int, address string) *TXOutput {
+	txo := &TXOutput{Time: time, ID: id, GPS: gps, Temperature: temper}
 	txo.Lock([]byte(address))
 
 	return txo
 }
 
+// Show prints the redemption conditions of the output
 func (out *TXOutput) Show() {
 	fmt.Printf("{\n")
-	fmt.Printf("Time: %d\n", out.Time)
-	fmt.Printf("GPS: %d\n", out.GPS)
-	fmt.Printf("Temperature: %d\n", out.Temperature)
+	fmt.Printf("Time: %s\n", out.timeCondition())
+	fmt.Printf("ID: %s\n", out.idCondition())
+	fmt.Printf("GPS: %s\n", out.gpsCondition())
+	fmt.Printf("Temperature: %s\n", out.temperCondition())
+	fmt.Printf("Expiry: %s\n", out.expiry())
+	fmt.Printf("Uses: %s\n", out.uses())
+	fmt.Printf("Holder: %s\n", out.holderChain())
 	fmt.Printf("}\n")
 }
 
+// Serialize serializes a single TXOutput
+func (out TXOutput) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(out)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeOutput deserializes a single TXOutput
+func DeserializeOutput(data []byte) TXOutput {
+	var output TXOutput
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&output)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return output
+}
+
 // TXOutputs collects TXOutput
 type TXOutputs struct {
 	Outputs []TXOutput
@@ -89,10 +196,165 @@ func DeserializeOutputs(data []byte) TXOutputs {
 	return outputs
 }
 
-func (out *TXOutput) CheckCondition(time *int, ID *[]byte, GPS *int, temper *int) bool {
-	//if !(out.checkTime(time)){return false}
-	//if !(out.checkID(ID)){return false}
-	//if !(out.checkGPS(GPS)){return false}
-	//if !(out.checkTemper(temper)){return false}
-	return *time == out.Time && bytes.Compare(*ID, out.ID) == 0 && *GPS == out.GPS && *temper == out.Temperature
+// CheckCondition checks the context supplied by the holder against the output's policy
+func (out *TXOutput) CheckCondition(ctx *RedeemContext) bool {
+	if ctx.Accuracy < 0 {
+		return false
+	}
+	if !(out.checkTime(ctx.Time)) {
+		return false
+	}
+	if !(out.checkID(ctx.ID)) {
+		return false
+	}
+	if !(out.checkGPS(ctx.GPS, ctx.Accuracy)) {
+		return false
+	}
+	if !(out.checkTemper(ctx.Temperature)) {
+		return false
+	}
+	return true
+}
+
+func (out *TXOutput) checkTime(time int) bool {
+	if len(out.Policy.TimeWindows) == 0 {
+		return time == out.Time
+	}
+
+	for _, w := range out.Policy.TimeWindows {
+		if w.Contains(time) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (out *TXOutput) checkID(id []byte) bool {
+	if len(out.Policy.IDs) == 0 {
+		return bytes.Compare(id, out.ID) == 0
+	}
+
+	return containsID(out.Policy.IDs, id)
+}
+
+func (out *TXOutput) checkGPS(gps Coordinate, accuracy int) bool {
+	if out.Policy.Geofence == nil {
+		return gps == out.GPS
+	}
+
+	return out.Policy.Geofence.Contains(gps, accuracy)
+}
+
+func (out *TXOutput) checkTemper(temper int) bool {
+	if out.Policy.Temperature == nil {
+		return temper == out.Temperature
+	}
+
+	return out.Policy.Temperature.Contains(temper)
+}
+
+// EditCondition updates the redemption conditions from CLI specs.
+// An empty spec leaves the condition untouched, a range spec ("MIN..MAX"),
+// an ID list ("a,b,c") or a geofence ("lat,lon,radius" or "lat,lon;lat,lon;...")
+// sets a predicate, any other value sets an exact match.
+func (out *TXOutput) EditCondition(time, id, gps, temper string) error {
+	var err error
+
+	if time != "" {
+		if IsRange(time) {
+			out.Policy.TimeWindows, err = ParseTimeWindows(time)
+		} else {
+			out.Time, err = strconv.Atoi(time)
+			out.Policy.TimeWindows = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if id != "" {
+		if strings.Contains(id, ",") {
+			out.Policy.IDs = ParseIDs(id)
+		} else {
+			out.ID = []byte(id)
+			out.Policy.IDs = nil
+		}
+	}
+	if gps != "" {
+		if IsGeofence(gps) {
+			out.Policy.Geofence, err = ParseGeofence(gps)
+		} else {
+			out.GPS, err = ParseCoordinate(gps)
+			out.Policy.Geofence = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if temper != "" {
+		if IsRange(temper) {
+			out.Policy.Temperature, err = ParseRange(temper)
+		} else {
+			out.Temperature, err = strconv.Atoi(temper)
+			out.Policy.Temperature = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (out *TXOutput) expiry() string {
+	var limits []string
+
+	if out.ExpiryHeight > 0 {
+		limits = append(limits, fmt.Sprintf("height %d", out.ExpiryHeight))
+	}
+	if out.ExpiryTime > 0 {
+		limits = append(limits, fmt.Sprintf("time %d", out.ExpiryTime))
+	}
+
+	if len(limits) == 0 {
+		return "never"
+	}
+
+	return strings.Join(limits, ", ")
+}
+
+func (out *TXOutput) uses() string {
+	if !out.Metered {
+		return "unlimited"
+	}
+
+	return fmt.Sprintf("%d remaining", out.RemainingUses)
+}
+
+func (out *TXOutput) timeCondition() string {
+	if len(out.Policy.TimeWindows) == 0 {
+		return strconv.Itoa(out.Time)
+	}
+	return joinTimeWindows(out.Policy.TimeWindows)
+}
+
+func (out *TXOutput) idCondition() string {
+	if len(out.Policy.IDs) == 0 {
+		return string(out.ID)
+	}
+	return joinIDs(out.Policy.IDs)
+}
+
+func (out *TXOutput) gpsCondition() string {
+	if out.Policy.Geofence == nil {
+		return out.GPS.String()
+	}
+	return out.Policy.Geofence.String()
+}
+
+func (out *TXOutput) temperCondition() string {
+	if out.Policy.Temperature == nil {
+		return strconv.Itoa(out.Temperature)
+	}
+	return out.Policy.Temperature.String()
 }
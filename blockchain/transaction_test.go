@@ -0,0 +1,156 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func newTestTokoin(owner, holder *wlt.Wallet) Transaction {
+	tx := NewCoinbaseTX(fmt.Sprintf("%s", owner.GetAddress()), "", 0, nil, Coordinate{}, 37, 0)
+	if holder != nil {
+		tx.Vout[0].Hold(holder.GetAddress())
+	}
+
+	return *tx
+}
+
+func spendTokoin(prev Transaction, op OpType, signer *wlt.Wallet, out TXOutput) Transaction {
+	return spendTokoinWith(prev, op, signer, out, OpPayload{Holder: out.HolderKey})
+}
+
+func spendTokoinWith(prev Transaction, op OpType, signer *wlt.Wallet, out TXOutput, payload OpPayload) Transaction {
+	tx := Transaction{nil, op, []TXInput{{prev.ID, 0, nil, signer.PublicKey, nil}}, []TXOutput{out}, payload}
+	tx.ID = tx.Hash()
+	tx.Sign(signer.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): prev})
+
+	return tx
+}
+
+func TestVerifySigners(t *testing.T) {
+	owner, holder, other := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	prev := newTestTokoin(owner, holder)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+
+	// the holder passes the tokoin on
+	out := prev.Vout[0]
+	out.Hold(other.GetAddress())
+	tx := spendTokoin(prev, OpTransfer, holder, out)
+	assert.True(t, tx.Verify(prevTXs))
+	assert.True(t, tx.VerifySigners(prevTXs))
+
+	// but cannot deposit it, which is up to the owner
+	tx = spendTokoin(prev, OpDeposit, holder, out)
+	assert.False(t, tx.VerifySigners(prevTXs))
+	tx = spendTokoin(prev, OpDeposit, owner, out)
+	assert.True(t, tx.Verify(prevTXs))
+	assert.True(t, tx.VerifySigners(prevTXs))
+
+	// the owner cannot redeem, only the holder can
+	out = prev.Vout[0]
+	out.HolderKey = nil
+	tx = spendTokoin(prev, OpRedeem, owner, out)
+	assert.False(t, tx.VerifySigners(prevTXs))
+	tx = spendTokoin(prev, OpRedeem, holder, out)
+	assert.True(t, tx.Verify(prevTXs))
+	assert.True(t, tx.VerifySigners(prevTXs))
+
+	// a stranger cannot spend the tokoin at all
+	tx = spendTokoin(prev, OpEdit, other, prev.Vout[0])
+	assert.False(t, tx.VerifySigners(prevTXs))
+
+	// creation is only possible through a coinbase
+	tx = spendTokoin(prev, OpCreate, owner, prev.Vout[0])
+	assert.False(t, tx.VerifySigners(prevTXs))
+}
+
+func TestCheckOperation(t *testing.T) {
+	owner, holder, other := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	prev := newTestTokoin(owner, holder)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+
+	// the payload is covered by the signature
+	out := prev.Vout[0]
+	out.Hold(other.GetAddress())
+	tx := spendTokoin(prev, OpTransfer, holder, out)
+	assert.Nil(t, tx.CheckOperation(prevTXs))
+	tx.Payload.Holder = prev.Vout[0].HolderKey
+	assert.False(t, tx.Verify(prevTXs))
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+
+	// a transfer cannot change the conditions
+	out.Temperature = 5
+	tx = spendTokoin(prev, OpTransfer, holder, out)
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+
+	// an edit cannot change the owner
+	out = prev.Vout[0]
+	out.Lock(other.GetAddress())
+	tx = spendTokoin(prev, OpEdit, owner, out)
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+	out = prev.Vout[0]
+	out.Temperature = 5
+	assert.Nil(t, out.EditExpiry("100", ""))
+	tx = spendTokoin(prev, OpEdit, owner, out)
+	assert.Nil(t, tx.CheckOperation(prevTXs))
+
+	// nor the uses left or the delegation chain
+	out = prev.Vout[0]
+	out.Meter(3)
+	tx = spendTokoin(prev, OpEdit, owner, out)
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+	out = prev.Vout[0]
+	out.Delegation = [][]byte{other.PublicKey}
+	tx = spendTokoin(prev, OpEdit, owner, out)
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+
+	// a redemption clears the holder and must satisfy the condition
+	out = prev.Vout[0]
+	out.HolderKey = nil
+	ctx := RedeemContext{Temperature: 37}
+	tx = spendTokoinWith(prev, OpRedeem, holder, out, OpPayload{Context: &ctx})
+	assert.Nil(t, tx.CheckOperation(prevTXs))
+	tx = spendTokoinWith(prev, OpRedeem, holder, prev.Vout[0], OpPayload{Context: &ctx})
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+	ctx.Temperature = 20
+	tx = spendTokoinWith(prev, OpRedeem, holder, out, OpPayload{Context: &ctx})
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+
+	// a discard burns the tokoin
+	tx = Transaction{nil, OpDiscard, []TXInput{{prev.ID, 0, nil, owner.PublicKey, nil}}, nil, OpPayload{}}
+	assert.Nil(t, tx.CheckOperation(prevTXs))
+	tx.Vout = []TXOutput{prev.Vout[0]}
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+}
+
+func TestMeteredRedeem(t *testing.T) {
+	owner, holder := wlt.NewWallet(), wlt.NewWallet()
+	prev := newTestTokoin(owner, holder)
+	prev.Vout[0].Meter(1)
+	prev.ID = prev.Hash()
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+	ctx := RedeemContext{Temperature: 37}
+
+	// a redemption must use up one of the remaining uses
+	out := prev.Vout[0]
+	out.HolderKey = nil
+	tx := spendTokoinWith(prev, OpRedeem, holder, out, OpPayload{Context: &ctx})
+	assert.NotNil(t, tx.CheckOperation(prevTXs))
+	out.RemainingUses = 0
+	tx = spendTokoinWith(prev, OpRedeem, holder, out, OpPayload{Context: &ctx})
+	assert.Nil(t, tx.CheckOperation(prevTXs))
+
+	// and an exhausted tokoin cannot be redeemed any more
+	spent := spendTokoinWith(prev, OpRedeem, holder, out, OpPayload{Context: &ctx})
+	spent.Vout[0].Hold(holder.GetAddress())
+	spent.ID = spent.Hash()
+	spentTXs := map[string]Transaction{hex.EncodeToString(spent.ID): spent}
+	tx = spendTokoinWith(spent, OpRedeem, holder, out, OpPayload{Context: &ctx})
+	assert.NotNil(t, tx.CheckOperation(spentTXs))
+	out.RemainingUses = -1
+	tx = spendTokoinWith(spent, OpRedeem, holder, out, OpPayload{Context: &ctx})
+	assert.NotNil(t, tx.CheckOperation(spentTXs))
+}
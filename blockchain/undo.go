@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// undoBucket maps the hash of each connected block to the journal of the
+// chainstate changes it made, so that it can be disconnected again
+const undoBucket = "undo"
+
+// undoDepth is the number of blocks below the tip whose journals are kept,
+// the journals of finalized blocks are dropped sooner. The chain cannot be
+// reorganized past a block without a journal.
+const undoDepth = 100
+
+// undoEntry is the value a key had before a block was connected,
+// Value is nil when the key did not exist
+type undoEntry struct {
+	Bucket string
+	Key    []byte
+	Value  []byte
+}
+
+// undoJournal applies the changes of a block to the chainstate, the
+// delegations and the revocations, recording the first value of each key
+type undoJournal struct {
+	tx      *bolt.Tx
+	Entries []undoEntry
+	seen    map[string]bool
+}
+
+func newUndoJournal(tx *bolt.Tx) *undoJournal {
+	return &undoJournal{tx, nil, make(map[string]bool)}
+}
+
+func (j *undoJournal) bucket(name string) *bolt.Bucket {
+	return j.tx.Bucket([]byte(name))
+}
+
+func (j *undoJournal) record(bucket string, key []byte) {
+	id := bucket + "/" + string(key)
+	if j.seen[id] {
+		return
+	}
+	j.seen[id] = true
+
+	var value []byte
+	if v := j.bucket(bucket).Get(key); v != nil {
+		value = append([]byte{}, v...)
+	}
+	j.Entries = append(j.Entries, undoEntry{bucket, append([]byte{}, key...), value})
+}
+
+// put sets the key, a chainstate value is a tokoin and is indexed, as is
+// the parent of a delegation
+func (j *undoJournal) put(bucket string, key, value []byte) error {
+	j.record(bucket, key)
+
+	switch bucket {
+	case urpoBucket:
+		out := DeserializeOutput(value)
+		return putOutput(j.bucket(bucket), key, &out)
+	case delegationBucket:
+		return putDelegation(j.bucket(bucket), key, value)
+	}
+	return j.bucket(bucket).Put(key, value)
+}
+
+func (j *undoJournal) delete(bucket string, key []byte) error {
+	j.record(bucket, key)
+
+	switch bucket {
+	case urpoBucket:
+		return deleteOutput(j.bucket(bucket), key)
+	case delegationBucket:
+		return deleteDelegation(j.bucket(bucket), key)
+	}
+	return j.bucket(bucket).Delete(key)
+}
+
+// save stores the journal under the hash of the block
+func (j *undoJournal) save(blockHash []byte) error {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(j.Entries)
+	if err != nil {
+		return err
+	}
+
+	return j.bucket(undoBucket).Put(blockHash, buff.Bytes())
+}
+
+// pruneUndo deletes the journals of the block and of its ancestors, down to
+// the first one that has none left
+func pruneUndo(tx *bolt.Tx, hash []byte) error {
+	undo := tx.Bucket([]byte(undoBucket))
+	b := tx.Bucket([]byte(blocksBucket))
+	if undo == nil {
+		return nil
+	}
+
+	for len(hash) > 0 && undo.Get(hash) != nil {
+		err := undo.Delete(hash)
+		if err != nil {
+			return err
+		}
+		hash = DeserializeBlock(b.Get(hash)).PrevBlockHash
+	}
+
+	return nil
+}
+
+// Rollback disconnects the block, which must be the last block applied by
+// Update, restoring the tokoins it spent and removing the ones it created
+func (u URPOSet) Rollback(block *Block) error {
+	db := u.Blockchain.db
+
+	return db.Update(func(tx *bolt.Tx) error {
+		undo := tx.Bucket([]byte(undoBucket))
+		var data []byte
+		if undo != nil {
+			data = undo.Get(block.Hash)
+		}
+		if data == nil {
+			return fmt.Errorf("block %x has no undo data", block.Hash)
+		}
+
+		var entries []undoEntry
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entries)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		// the journal itself is not recorded
+		j := newUndoJournal(tx)
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if e.Value == nil {
+				err = j.delete(e.Bucket, e.Key)
+			} else {
+				err = j.put(e.Bucket, e.Key, e.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return undo.Delete(block.Hash)
+	})
+}
@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// dumpChainstate returns the contents of every bucket Update and Rollback maintain
+func dumpChainstate(t *testing.T, bc *Blockchain) map[string]map[string]string {
+	dump := make(map[string]map[string]string)
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		for _, name := range []string{urpoBucket, ownerIndexBucket, holderIndexBucket, delegationBucket, childDelegationBucket, revocationBucket} {
+			dump[name] = make(map[string]string)
+			b := tx.Bucket([]byte(name))
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(k, v []byte) error {
+				dump[name][fmt.Sprintf("%x", k)] = fmt.Sprintf("%x", v)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dump
+}
+
+func TestRollback(t *testing.T) {
+	owner, fired, contractor := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	genesis := bc.Iterator().Next().Transactions[0]
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	firedAddress := fmt.Sprintf("%s", fired.GetAddress())
+
+	var blocks []*Block
+	var states []map[string]map[string]string
+	connect := func(txs ...*Transaction) {
+		states = append(states, dumpChainstate(t, bc))
+		blocks = append(blocks, mineTestBlock(t, bc, txs...))
+	}
+
+	tokoin := NewTXOutput(0, nil, Coordinate{}, 37, ownerAddress)
+	issue := NewIssuanceTX("", []TXOutput{*tokoin, *tokoin, *tokoin})
+	connect(issue, EditPolicy(*owner, &urpo, Outpoint{genesis.ID, 0}, "", "", "", "", "2", ""))
+	deposit := Deposit(owner, firedAddress, &urpo, Outpoint{issue.ID, 0})
+	connect(deposit, Deposit(owner, firedAddress, &urpo, Outpoint{issue.ID, 1}))
+	delegate := Delegate(*fired, fmt.Sprintf("%s", contractor.GetAddress()), &urpo, Outpoint{deposit.ID, 0}, "100", "")
+	connect(delegate)
+	connect(RevokeHolder(*owner, ownerAddress, firedAddress, &urpo))
+	for bc.GetBestHeight() <= 2+expiryGraceBlocks {
+		connect(NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 37, 0))
+	}
+	assert.False(t, urpo.IsUnspent(Outpoint{blocks[0].Transactions[1].ID, 0}))
+	final := dumpChainstate(t, bc)
+	assert.True(t, urpo.IsRevoked(wlt.HashPubKey(owner.PublicKey), wlt.HashPubKey(fired.PublicKey)))
+
+	// disconnecting the blocks one by one restores each earlier state,
+	// including the pruned, delegated and revoked tokoins
+	for i := len(blocks) - 1; i >= 0; i-- {
+		assert.Nil(t, urpo.Rollback(blocks[i]))
+		assert.Equal(t, states[i], dumpChainstate(t, bc), "before block %d", i+1)
+	}
+	assert.NotNil(t, urpo.Rollback(blocks[0]))
+
+	// and connecting them again gives the state a full reindex gives
+	for _, block := range blocks {
+		urpo.Update(block)
+	}
+	assert.Equal(t, final, dumpChainstate(t, bc))
+	urpo.Reindex()
+	assert.Equal(t, final, dumpChainstate(t, bc))
+
+	// the journals of a finalized block and of its ancestors are dropped
+	hasUndo := func(block *Block) bool {
+		found := false
+		err := bc.db.View(func(tx *bolt.Tx) error {
+			found = tx.Bucket([]byte(undoBucket)).Get(block.Hash) != nil
+			return nil
+		})
+		assert.Nil(t, err)
+		return found
+	}
+	last := len(blocks) - 1
+	assert.Nil(t, bc.Finalize(blocks[last-1].Hash))
+	for _, block := range blocks[:last] {
+		assert.False(t, hasUndo(block))
+	}
+	assert.True(t, hasUndo(blocks[last]))
+	assert.NotNil(t, urpo.Rollback(blocks[last-1]))
+}
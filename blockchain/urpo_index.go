@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// The owner and holder indexes map a key hash to the outpoints of the
+// unspent tokoins it owns or holds. They are built by Reindex and kept in
+// step with the chainstate by putOutput and deleteOutput, a chainstate
+// created before the indexes existed is scanned until it is reindexed.
+// The expiry index is kept the same way.
+const ownerIndexBucket = "ownerindex"
+const holderIndexBucket = "holderindex"
+
+// expiryIndexBucket maps the expiry height or time of each unspent tokoin
+// that has one to its outpoint, in expiry order, so that the lapsed
+// tokoins are found without scanning the chainstate
+const expiryIndexBucket = "expiries"
+
+// The expiry index keys start with the kind of the expiry
+const (
+	expiryHeightPrefix = 'h'
+	expiryTimePrefix   = 't'
+)
+
+// indexKey prefixes the outpoint key with the length and the bytes of the key hash
+func indexKey(keyHash, outpointKey []byte) []byte {
+	key := append([]byte{byte(len(keyHash))}, keyHash...)
+
+	return append(key, outpointKey...)
+}
+
+func indexPrefix(keyHash []byte) []byte {
+	return indexKey(keyHash, nil)
+}
+
+// expiryKey prefixes the outpoint key with the kind and the big-endian expiry
+func expiryKey(kind byte, expiry int64, outpointKey []byte) []byte {
+	key := make([]byte, 9, 9+len(outpointKey))
+	key[0] = kind
+	binary.BigEndian.PutUint64(key[1:], uint64(expiry))
+
+	return append(key, outpointKey...)
+}
+
+// putOutput stores the tokoin in the chainstate and indexes it
+func putOutput(b *bolt.Bucket, key []byte, out *TXOutput) error {
+	err := deleteOutput(b, key)
+	if err != nil {
+		return err
+	}
+	err = b.Put(key, out.Serialize())
+	if err != nil {
+		return err
+	}
+
+	return updateIndexes(b, key, out, (*bolt.Bucket).Put)
+}
+
+// deleteOutput removes the tokoin from the chainstate and from the indexes
+func deleteOutput(b *bolt.Bucket, key []byte) error {
+	v := b.Get(key)
+	if v == nil {
+		return nil
+	}
+	out := DeserializeOutput(v)
+
+	err := updateIndexes(b, key, &out, func(idx *bolt.Bucket, k, _ []byte) error {
+		return idx.Delete(k)
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.Delete(key)
+}
+
+func updateIndexes(b *bolt.Bucket, key []byte, out *TXOutput, update func(*bolt.Bucket, []byte, []byte) error) error {
+	if o := b.Tx().Bucket([]byte(ownerIndexBucket)); o != nil {
+		err := update(o, indexKey(out.PubKeyHash, key), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	if h := b.Tx().Bucket([]byte(holderIndexBucket)); h != nil && len(out.HolderKey) > 0 {
+		err := update(h, indexKey(out.HolderKey, key), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	if e := b.Tx().Bucket([]byte(expiryIndexBucket)); e != nil {
+		if out.ExpiryHeight > 0 {
+			err := update(e, expiryKey(expiryHeightPrefix, int64(out.ExpiryHeight), key), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+		if out.ExpiryTime > 0 {
+			err := update(e, expiryKey(expiryTimePrefix, out.ExpiryTime, key), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// expiredKeys returns the chainstate keys of the tokoins that have lapsed
+// for a block at the given height and time, the chainstate is scanned
+// when the expiry index is missing
+func expiredKeys(tx *bolt.Tx, height int, timestamp int64) [][]byte {
+	var keys [][]byte
+	idx := tx.Bucket([]byte(expiryIndexBucket))
+
+	if idx == nil {
+		c := tx.Bucket([]byte(urpoBucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			out := DeserializeOutput(v)
+			if out.IsExpired(height, timestamp) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		return keys
+	}
+
+	seen := make(map[string]bool)
+	scan := func(kind byte, limit int64) {
+		c := idx.Cursor()
+		for k, _ := c.Seek([]byte{kind}); k != nil && k[0] == kind; k, _ = c.Next() {
+			if int64(binary.BigEndian.Uint64(k[1:9])) >= limit {
+				break
+			}
+			if key := string(k[9:]); !seen[key] {
+				seen[key] = true
+				keys = append(keys, []byte(key))
+			}
+		}
+	}
+	scan(expiryHeightPrefix, int64(height))
+	scan(expiryTimePrefix, timestamp)
+
+	return keys
+}
+
+// findIndexed returns the unspent tokoins under the key hash in the index,
+// in outpoint order, match is used instead when the index is missing
+func (u URPOSet) findIndexed(index string, keyHash []byte, match func(*TXOutput) bool) ([]Outpoint, []TXOutput) {
+	var outpoints []Outpoint
+	var outs []TXOutput
+	db := u.Blockchain.db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(urpoBucket))
+		idx := tx.Bucket([]byte(index))
+
+		if idx == nil {
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				out := DeserializeOutput(v)
+				if match(&out) {
+					outpoints = append(outpoints, OutpointFromKey(k))
+					outs = append(outs, out)
+				}
+			}
+			return nil
+		}
+
+		prefix := indexPrefix(keyHash)
+		c := idx.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			key := k[len(prefix):]
+			outpoints = append(outpoints, OutpointFromKey(key))
+			outs = append(outs, DeserializeOutput(b.Get(key)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return outpoints, outs
+}
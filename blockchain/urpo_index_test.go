@@ -0,0 +1,46 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestURPOIndexes(t *testing.T) {
+	owner, holder, contractor := wlt.NewWallet(), wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	genesis := bc.Iterator().Next().Transactions[0]
+	ownerKey, holderKey, contractorKey := wlt.HashPubKey(owner.PublicKey), wlt.HashPubKey(holder.PublicKey), wlt.HashPubKey(contractor.PublicKey)
+
+	issue := NewCoinbaseTX(fmt.Sprintf("%s", owner.GetAddress()), "", 0, nil, Coordinate{}, 37, 0)
+	deposit := Deposit(owner, fmt.Sprintf("%s", holder.GetAddress()), &urpo, Outpoint{genesis.ID, 0})
+	mineTestBlock(t, bc, issue, deposit)
+	delegate := Delegate(*holder, fmt.Sprintf("%s", contractor.GetAddress()), &urpo, Outpoint{deposit.ID, 0}, "10", "")
+	mineTestBlock(t, bc, delegate)
+
+	assert.Equal(t, 3, len(urpo.FindURPO(ownerKey)))
+	assert.Equal(t, []string{Outpoint{delegate.ID, 0}.String()}, urpo.FindHeldURPOIndexs(holderKey))
+	assert.Equal(t, []string{Outpoint{delegate.ID, 1}.String()}, urpo.FindHeldURPOIndexs(contractorKey))
+	assert.Equal(t, contractorKey, urpo.FindHeldURPO(contractorKey)[0].HolderKey)
+
+	// spending the parent drops the revoked delegation from the indexes too
+	ctx := RedeemContext{Temperature: 37}
+	mineTestBlock(t, bc, RedeemTokoin(*holder, fmt.Sprintf("%s", owner.GetAddress()), &urpo, Outpoint{delegate.ID, 0}, &ctx))
+	assert.Empty(t, urpo.FindHeldURPO(holderKey))
+	assert.Empty(t, urpo.FindHeldURPO(contractorKey))
+	assert.Equal(t, 2, len(urpo.FindURPO(ownerKey)))
+	indexed := urpo.FindURPOIndexs(ownerKey)
+
+	// a chainstate without the indexes is scanned instead
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(ownerIndexBucket))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, indexed, urpo.FindURPOIndexs(ownerKey))
+	urpo.Reindex()
+	assert.Equal(t, indexed, urpo.FindURPOIndexs(ownerKey))
+}
@@ -1,6 +1,8 @@
 package blockchain
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log"
@@ -10,6 +12,13 @@ import (
 
 const urpoBucket = "chainstate"
 
+// A lapsed tokoin stays in the chainstate for a grace window, during which
+// only its owner can still extend or discard it, before it is pruned
+const (
+	expiryGraceBlocks = 10
+	expiryGraceTime   = expiryGraceBlocks * targetSpacing
+)
+
 // URPOSet represents URPO set
 type URPOSet struct {
 	Blockchain *Blockchain
@@ -18,21 +27,28 @@ type URPOSet struct {
 // FindSpendableOutputs finds and returns unspent outputs to reference in inputs
 func (u URPOSet) FindSpendableOutputs(pubkeyHash []byte) map[string][]int {
 	unspentOutputs := make(map[string][]int)
+
+	for _, op := range u.FindURPOIndexs(pubkeyHash) {
+		outpoint, err := ParseOutpoint(op)
+		if err != nil {
+			log.Panic(err)
+		}
+		txID := hex.EncodeToString(outpoint.Txid)
+		unspentOutputs[txID] = append(unspentOutputs[txID], outpoint.Vout)
+	}
+
+	return unspentOutputs
+}
+
+// FindOutput returns the unspent tokoin at the outpoint
+func (u URPOSet) FindOutput(outpoint Outpoint) (TXOutput, error) {
+	var outBytes []byte
 	db := u.Blockchain.db
 
 	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(urpoBucket))
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
-
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyHash) {
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-				}
-			}
+		if v := b.Get(outpoint.Key()); v != nil {
+			outBytes = append([]byte{}, v...)
 		}
 
 		return nil
@@ -41,39 +57,24 @@ func (u URPOSet) FindSpendableOutputs(pubkeyHash []byte) map[string][]int {
 		log.Panic(err)
 	}
 
-	return unspentOutputs
-}
-
-func (u URPOSet) FindOutput(txId []byte) TXOutput {
-	tx, err := u.Blockchain.FindTransaction(txId)
-	if err != nil {
-		log.Panic(err)
-	}
-	if len(tx.Vout) == 0 {
-		fmt.Println("No output was found in this transaction!")
-		return TXOutput{0, nil, 0, 0, nil, nil}
+	if outBytes == nil {
+		tx, err := u.Blockchain.FindTransaction(outpoint.Txid)
+		if err != nil || outpoint.Vout < 0 || outpoint.Vout >= len(tx.Vout) {
+			return TXOutput{}, fmt.Errorf("tokoin %s does not exist", outpoint)
+		}
+		return TXOutput{}, fmt.Errorf("tokoin %s is already spent or revoked", outpoint)
 	}
-	return tx.Vout[0]
+
+	return DeserializeOutput(outBytes), nil
 }
 
-// FindUTXO finds UTXO for a public key hash
-func (u URPOSet) FindURPO(pubKeyHash []byte) []TXOutput {
-	var URPOs []TXOutput
+// IsUnspent checks whether the outpoint is still in the URPO set
+func (u URPOSet) IsUnspent(outpoint Outpoint) bool {
+	found := false
 	db := u.Blockchain.db
 
 	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(urpoBucket))
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
-
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					URPOs = append(URPOs, out)
-				}
-			}
-		}
+		found = tx.Bucket([]byte(urpoBucket)).Get(outpoint.Key()) != nil
 
 		return nil
 	})
@@ -81,35 +82,53 @@ func (u URPOSet) FindURPO(pubKeyHash []byte) []TXOutput {
 		log.Panic(err)
 	}
 
+	return found
+}
+
+// FindUTXO finds UTXO for a public key hash
+func (u URPOSet) FindURPO(pubKeyHash []byte) []TXOutput {
+	_, URPOs := u.findIndexed(ownerIndexBucket, pubKeyHash, func(out *TXOutput) bool {
+		return out.IsLockedWithKey(pubKeyHash)
+	})
+
 	return URPOs
 }
 
-//
+// FindURPOIndexs finds the outpoints of the URPO of a public key hash, in the same order as FindURPO
 func (u URPOSet) FindURPOIndexs(pubKeyHash []byte) []string {
-	var txIDs []string
-	db := u.Blockchain.db
+	var outpoints []string
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(urpoBucket))
-		c := b.Cursor()
+	ops, _ := u.findIndexed(ownerIndexBucket, pubKeyHash, func(out *TXOutput) bool {
+		return out.IsLockedWithKey(pubKeyHash)
+	})
+	for _, op := range ops {
+		outpoints = append(outpoints, op.String())
+	}
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
+	return outpoints
+}
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					txIDs = append(txIDs, hex.EncodeToString(k))
-				}
-			}
-		}
+// FindHeldURPO finds the unspent tokoins held with the holder key
+func (u URPOSet) FindHeldURPO(holderKey []byte) []TXOutput {
+	_, URPOs := u.findIndexed(holderIndexBucket, holderKey, func(out *TXOutput) bool {
+		return len(holderKey) > 0 && out.IsHeldWithKey(holderKey)
+	})
 
-		return nil
+	return URPOs
+}
+
+// FindHeldURPOIndexs finds the outpoints of the tokoins held with the holder key, in the same order as FindHeldURPO
+func (u URPOSet) FindHeldURPOIndexs(holderKey []byte) []string {
+	var outpoints []string
+
+	ops, _ := u.findIndexed(holderIndexBucket, holderKey, func(out *TXOutput) bool {
+		return len(holderKey) > 0 && out.IsHeldWithKey(holderKey)
 	})
-	if err != nil {
-		log.Panic(err)
+	for _, op := range ops {
+		outpoints = append(outpoints, op.String())
 	}
 
-	return txIDs
+	return outpoints
 }
 
 // CountTransactions returns the number of transactions in the UTXO set
@@ -120,9 +139,15 @@ func (u URPOSet) CountTransactions() int {
 	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(urpoBucket))
 		c := b.Cursor()
+		var lastTxid []byte
 
+		// keys of the same transaction are adjacent
 		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			counter++
+			txid := OutpointFromKey(k).Txid
+			if !bytes.Equal(txid, lastTxid) {
+				counter++
+				lastTxid = txid
+			}
 		}
 
 		return nil
@@ -134,20 +159,21 @@ func (u URPOSet) CountTransactions() int {
 	return counter
 }
 
-// Reindex rebuilds the UTXO set
+// Reindex rebuilds the UTXO set by replaying the blockchain from the genesis block
 func (u URPOSet) Reindex() {
 	db := u.Blockchain.db
-	bucketName := []byte(urpoBucket)
 
 	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
-		}
+		for _, bucketName := range []string{urpoBucket, ownerIndexBucket, holderIndexBucket, expiryIndexBucket, delegationBucket, childDelegationBucket, revocationBucket, undoBucket} {
+			err := tx.DeleteBucket([]byte(bucketName))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				log.Panic(err)
+			}
 
-		_, err = tx.CreateBucket(bucketName)
-		if err != nil {
-			log.Panic(err)
+			_, err = tx.CreateBucket([]byte(bucketName))
+			if err != nil {
+				log.Panic(err)
+			}
 		}
 
 		return nil
@@ -156,25 +182,48 @@ func (u URPOSet) Reindex() {
 		log.Panic(err)
 	}
 
-	URPO := u.Blockchain.FindURPO()
+	var blocks []*Block
+	bci := u.Blockchain.Iterator()
+	for {
+		block := bci.Next()
+		blocks = append(blocks, block)
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
 
-		for txID, outs := range URPO {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
-			}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		u.Update(blocks[i])
+	}
+}
 
-			err = b.Put(key, outs.Serialize())
-			if err != nil {
-				log.Panic(err)
-			}
+// upgrade rebuilds a chainstate that is missing or that still maps each
+// txid to all its outputs, as before tokoins were keyed by outpoint
+func (u URPOSet) upgrade() bool {
+	old := false
+	db := u.Blockchain.db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(urpoBucket))
+		if b == nil {
+			old = true
+			return nil
 		}
+		k, _ := b.Cursor().First()
+		old = k != nil && len(k) == sha256.Size
 
 		return nil
 	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if old {
+		u.Reindex()
+	}
+
+	return old
 }
 
 // Update updates the UTXO set with transactions from the Block
@@ -183,50 +232,91 @@ func (u URPOSet) Update(block *Block) {
 	db := u.Blockchain.db
 
 	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(urpoBucket))
+		for _, bucketName := range []string{delegationBucket, revocationBucket, undoBucket} {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+			if err != nil {
+				return err
+			}
+		}
+		j := newUndoJournal(tx)
 
 		for _, tx := range block.Transactions {
 			if tx.IsCoinbase() == false {
 				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
+					err := j.delete(urpoBucket, vin.Outpoint().Key())
+					if err != nil {
+						log.Panic(err)
 					}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
+					// delegations follow their parent when it is delegated again
+					// and are revoked by anything else that spends it
+					if tx.Type == OpDelegate {
+						err = moveDelegations(j, vin.Outpoint(), Outpoint{tx.ID, 0})
 					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
+						err = revokeDelegations(j, vin.Outpoint())
+					}
+					if err != nil {
+						return err
 					}
-
 				}
 			}
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+			for outIdx, out := range tx.Vout {
+				err := j.put(urpoBucket, Outpoint{tx.ID, outIdx}.Key(), out.Serialize())
+				if err != nil {
+					log.Panic(err)
+				}
 			}
 
-			err := b.Put(tx.ID, newOutputs.Serialize())
-			if err != nil {
-				log.Panic(err)
+			switch tx.Type {
+			case OpDelegate:
+				err := j.put(delegationBucket, Outpoint{tx.ID, 1}.Key(), Outpoint{tx.ID, 0}.Key())
+				if err != nil {
+					return err
+				}
+			case OpRevokeHolder:
+				err := j.put(revocationBucket, revocationKey(tx.Payload.Owner, tx.Payload.Holder), tx.ID)
+				if err != nil {
+					return err
+				}
 			}
 		}
 
-		return nil
+		err := pruneExpired(j, block)
+		if err != nil {
+			return err
+		}
+		err = j.save(block.Hash)
+		if err != nil {
+			return err
+		}
+
+		if block.Height < undoDepth {
+			return nil
+		}
+		old := tx.Bucket([]byte(heightIndexBucket)).Get(heightKey(block.Height - undoDepth))
+		return pruneUndo(tx, append([]byte{}, old...))
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 }
+
+// pruneExpired removes the outputs that lapsed more than the grace window
+// before the block once it is on the chain
+func pruneExpired(j *undoJournal, block *Block) error {
+	expired := expiredKeys(j.tx, block.Height-expiryGraceBlocks, block.Timestamp-expiryGraceTime)
+
+	for _, k := range expired {
+		err := j.delete(urpoBucket, k)
+		if err != nil {
+			return err
+		}
+		err = revokeDelegationKey(j, k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestExpiry(t *testing.T) {
+	owner, holder := wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	genesis := bc.Iterator().Next().Transactions[0]
+	ownerAddress := fmt.Sprintf("%s", owner.GetAddress())
+	holderAddress := fmt.Sprintf("%s", holder.GetAddress())
+
+	edit := EditPolicy(*owner, &urpo, Outpoint{genesis.ID, 0}, "", "", "", "", "2", "")
+	mineTestBlock(t, bc, edit)
+	assert.Equal(t, 2, edit.Vout[0].ExpiryHeight)
+
+	// the tokoin can still be used in block 2
+	deposit := Deposit(owner, holderAddress, &urpo, Outpoint{edit.ID, 0})
+	assert.Nil(t, bc.ValidateTransaction(deposit))
+	mineTestBlock(t, bc, NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 0, 0))
+
+	// but not in block 3, although the owner can still extend it
+	assert.NotNil(t, bc.ValidateTransaction(deposit))
+	extend := EditPolicy(*owner, &urpo, Outpoint{edit.ID, 0}, "", "", "", "", "10", "")
+	assert.Nil(t, bc.ValidateTransaction(extend))
+
+	// until the grace window after its expiry has passed
+	for bc.GetBestHeight() < 2+expiryGraceBlocks {
+		mineTestBlock(t, bc, NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 0, 0))
+	}
+	assert.True(t, urpo.IsUnspent(Outpoint{edit.ID, 0}))
+	assert.Nil(t, bc.ValidateTransaction(extend))
+
+	// the lapsed tokoin is found with the expiry index and without it,
+	// as in a chainstate created before the index
+	expired := func() [][]byte {
+		var keys [][]byte
+		err := bc.db.View(func(tx *bolt.Tx) error {
+			keys = expiredKeys(tx, 3, 0)
+			return nil
+		})
+		assert.Nil(t, err)
+		return keys
+	}
+	assert.Equal(t, [][]byte{Outpoint{edit.ID, 0}.Key()}, expired())
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(expiryIndexBucket))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{Outpoint{edit.ID, 0}.Key()}, expired())
+
+	// then the tokoin is pruned
+	mineTestBlock(t, bc, NewCoinbaseTX(ownerAddress, "", 0, nil, Coordinate{}, 0, 0))
+	assert.False(t, urpo.IsUnspent(Outpoint{edit.ID, 0}))
+	assert.NotNil(t, bc.ValidateTransaction(extend))
+
+	urpo.Reindex()
+	assert.False(t, urpo.IsUnspent(Outpoint{edit.ID, 0}))
+	assert.Empty(t, expired())
+}
@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TxValidator checks transactions against the URPO set and against the
+// transactions it has already accepted for the same block
+type TxValidator struct {
+	bc        *Blockchain
+	urpo      URPOSet
+	spent     map[string]bool
+	txIDs     map[string]bool
+	revoked   map[string]bool
+	height    int
+	timestamp int64
+}
+
+// NewTxValidator creates a TxValidator for a block mined now on top of the current tip
+func NewTxValidator(bc *Blockchain) *TxValidator {
+	return &TxValidator{bc, URPOSet{bc}, make(map[string]bool), make(map[string]bool), make(map[string]bool), bc.GetBestHeight() + 1, time.Now().Unix()}
+}
+
+// Accept validates a transaction and, if it is valid, records its inputs as
+// spent so that later transactions of the block cannot spend them again
+func (v *TxValidator) Accept(tx *Transaction) error {
+	err := v.check(tx)
+	if err != nil {
+		return err
+	}
+
+	v.txIDs[hex.EncodeToString(tx.ID)] = true
+	for _, vin := range tx.Vin {
+		v.spent[vin.Outpoint().String()] = true
+	}
+	if tx.Type == OpRevokeHolder {
+		v.revoked[string(revocationKey(tx.Payload.Owner, tx.Payload.Holder))] = true
+	}
+
+	return nil
+}
+
+func (v *TxValidator) check(tx *Transaction) error {
+	err := checkTransaction(tx)
+	if err != nil {
+		return err
+	}
+	if v.txIDs[hex.EncodeToString(tx.ID)] {
+		return errors.New("transaction is duplicated")
+	}
+	if _, err := v.bc.FindTransaction(tx.ID); err == nil {
+		return errors.New("transaction is already on the chain")
+	}
+	if tx.IsCoinbase() {
+		return v.checkRevoked(tx, nil)
+	}
+
+	prevTXs := make(map[string]Transaction)
+	var prevOuts []TXOutput
+
+	for _, vin := range tx.Vin {
+		outpoint := vin.Outpoint()
+		key := outpoint.String()
+		if v.spent[key] {
+			return fmt.Errorf("input %s is already spent in this block", outpoint)
+		}
+
+		prevOut, err := v.urpo.FindOutput(outpoint)
+		if err != nil {
+			return err
+		}
+		if usesTokoin(tx.Type) && prevOut.IsExpired(v.height, v.timestamp) {
+			return fmt.Errorf("input %s has expired", outpoint)
+		}
+		prevTX, err := v.bc.FindTransaction(vin.Txid)
+		if err != nil {
+			return fmt.Errorf("input %s does not exist", outpoint)
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+		prevOuts = append(prevOuts, prevOut)
+	}
+
+	if !tx.Verify(prevTXs) {
+		return errors.New("invalid signature")
+	}
+	if !tx.VerifySigners(prevTXs) {
+		return fmt.Errorf("inputs must be signed by the %s", tx.Type.SignerRole())
+	}
+
+	err = tx.CheckOperation(prevTXs)
+	if err != nil {
+		return err
+	}
+
+	return v.checkRevoked(tx, prevOuts)
+}
+
+// checkTransaction runs the checks that do not depend on the chain state
+func checkTransaction(tx *Transaction) error {
+	if len(tx.ID) == 0 {
+		return errors.New("transaction has no ID")
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return errors.New("transaction ID is not its hash")
+	}
+
+	if tx.IsCoinbase() {
+		if tx.Type != OpCreate {
+			return fmt.Errorf("coinbase transaction cannot %s a tokoin", tx.Type)
+		}
+		if len(tx.Vout) == 0 {
+			return errors.New("coinbase transaction creates no tokoin")
+		}
+		for _, out := range tx.Vout {
+			if len(out.PubKeyHash) == 0 {
+				return errors.New("coinbase transaction creates a tokoin without owner")
+			}
+		}
+		return tx.CheckOperation(nil)
+	}
+
+	if tx.Type == OpCreate {
+		return errors.New("tokoins can only be created by a coinbase transaction")
+	}
+	if len(tx.Vin) == 0 && !tx.signsPayload() {
+		return errors.New("transaction spends no tokoin")
+	}
+
+	inputs := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		key := vin.Outpoint().String()
+		if inputs[key] {
+			return fmt.Errorf("input %s is duplicated", vin.Outpoint())
+		}
+		inputs[key] = true
+	}
+
+	return nil
+}
+
+// checkRevoked rejects creating a tokoin held through a holder its owner
+// has revoked, whatever the operation, and redeeming a tokoin through one
+func (v *TxValidator) checkRevoked(tx *Transaction, prevOuts []TXOutput) error {
+	outs := tx.Vout
+	if tx.Type == OpRedeem {
+		outs = append(append([]TXOutput{}, outs...), prevOuts...)
+	}
+
+	for _, out := range outs {
+		holders := append(append([][]byte{}, out.Delegation...), out.HolderKey)
+		for _, holder := range holders {
+			if len(holder) > 0 && v.isRevoked(out.PubKeyHash, holder) {
+				return fmt.Errorf("holder %x has been revoked by the owner", holder)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *TxValidator) isRevoked(owner, holder []byte) bool {
+	return v.revoked[string(revocationKey(owner, holder))] || v.urpo.IsRevoked(owner, holder)
+}
+
+// ValidateTransaction checks a single transaction against the URPO set
+func (bc *Blockchain) ValidateTransaction(tx *Transaction) error {
+	return NewTxValidator(bc).Accept(tx)
+}
+
+// checkBlock runs the checks of all transactions of a block that do not
+// depend on the chain state, for blocks stored on a side branch
+func checkBlock(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return errors.New("block has no transactions")
+	}
+
+	txIDs := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		err := checkTransaction(tx)
+		if err == nil && txIDs[hex.EncodeToString(tx.ID)] {
+			err = errors.New("transaction is duplicated")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s transaction %x: %s", tx.Type, tx.ID, err)
+		}
+		txIDs[hex.EncodeToString(tx.ID)] = true
+	}
+
+	return nil
+}
+
+// ValidateBlock checks all transactions of a block on top of the current tip,
+// the whole block is rejected if any of them fails
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return errors.New("block has no transactions")
+	}
+
+	v := NewTxValidator(bc)
+	v.height, v.timestamp = block.Height, block.Timestamp
+	for _, tx := range block.Transactions {
+		err := v.Accept(tx)
+		if err != nil {
+			return fmt.Errorf("invalid %s transaction %x: %s", tx.Type, tx.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// usesTokoin checks whether an operation makes use of a tokoin rather than
+// managing it, only the owner can still edit or discard an expired tokoin
+func usesTokoin(op OpType) bool {
+	return op == OpDeposit || op == OpTransfer || op == OpRedeem || op == OpDelegate
+}
@@ -0,0 +1,202 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// Validator is a node that proposes and votes on blocks in Tendermint
+type Validator struct {
+	// Address is the network address of the node
+	Address string `json:"address"`
+	// PubKey is the hex encoded public key the node signs its messages
+	// with, only the default validators have none and cannot run Tendermint
+	PubKey      string `json:"pubkey"`
+	VotingPower int64  `json:"power"`
+}
+
+// ValidatorSet is the genesis set of Tendermint validators, a block is
+// committed by validators with more than 2/3 of the total voting power
+type ValidatorSet struct {
+	Validators []Validator `json:"validators"`
+}
+
+// DefaultValidatorSet returns four validators of equal power on
+// localhost:3000-3003, they have no keys so Tendermint needs a genesis file
+func DefaultValidatorSet() *ValidatorSet {
+	set := &ValidatorSet{}
+	for i := 0; i < 4; i++ {
+		set.Validators = append(set.Validators, Validator{fmt.Sprintf("localhost:300%d", i), "", 1})
+	}
+
+	return set
+}
+
+// LoadValidatorSet reads a validator set from a JSON genesis file
+func LoadValidatorSet(path string) (*ValidatorSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set ValidatorSet
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validator set %s: %s", path, err)
+	}
+
+	err = set.check()
+	if err != nil {
+		return nil, fmt.Errorf("invalid validator set %s: %s", path, err)
+	}
+
+	return &set, nil
+}
+
+func (set *ValidatorSet) check() error {
+	if len(set.Validators) == 0 {
+		return fmt.Errorf("there are no validators")
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range set.Validators {
+		if v.Address == "" {
+			return fmt.Errorf("a validator has no address")
+		}
+		if seen[v.Address] {
+			return fmt.Errorf("validator %s is listed twice", v.Address)
+		}
+		seen[v.Address] = true
+
+		if v.VotingPower <= 0 {
+			return fmt.Errorf("validator %s has no voting power", v.Address)
+		}
+		if key, err := hex.DecodeString(v.PubKey); err != nil || len(key) != 64 {
+			return fmt.Errorf("validator %s has an invalid public key", v.Address)
+		}
+	}
+
+	return nil
+}
+
+// SigningKey returns the key of the validator at the address from the
+// wallets, or nil if the address is not a validator with a public key
+func (set *ValidatorSet) SigningKey(address string, wallets *wlt.Wallets) (*ecdsa.PrivateKey, error) {
+	v := set.Get(address)
+	if v == nil || v.PubKey == "" {
+		return nil, nil
+	}
+
+	pubKey, err := hex.DecodeString(v.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	w, ok := wallets.Wallets[string(wlt.KeyHashToAddress(wlt.HashPubKey(pubKey)))]
+	if !ok {
+		return nil, fmt.Errorf("the wallet of validator %s is missing", address)
+	}
+
+	return &w.PrivateKey, nil
+}
+
+// Get returns the validator at the address, or nil if it is not one
+func (set *ValidatorSet) Get(address string) *Validator {
+	for i := range set.Validators {
+		if set.Validators[i].Address == address {
+			return &set.Validators[i]
+		}
+	}
+
+	return nil
+}
+
+// Power returns the voting power of the validator at the address, 0 if it is not one
+func (set *ValidatorSet) Power(address string) int64 {
+	if v := set.Get(address); v != nil {
+		return v.VotingPower
+	}
+
+	return 0
+}
+
+// TotalPower returns the voting power of all the validators
+func (set *ValidatorSet) TotalPower() int64 {
+	var total int64
+	for _, v := range set.Validators {
+		total += v.VotingPower
+	}
+
+	return total
+}
+
+// Quorum returns the smallest voting power above 2/3 of the total
+func (set *ValidatorSet) Quorum() int64 {
+	return set.TotalPower()*2/3 + 1
+}
+
+// OneThird returns the smallest voting power above 1/3 of the total, it
+// includes at least one honest validator
+func (set *ValidatorSet) OneThird() int64 {
+	return set.TotalPower()/3 + 1
+}
+
+// maxProposerCycle bounds the number of turns of a cycle of proposers,
+// larger voting powers are scaled down to it
+const maxProposerCycle = 1000
+
+// proposerPowers returns the voting powers the proposers take turns by,
+// divided by their greatest common divisor and scaled down so that their
+// total is about maxProposerCycle at most
+func (set *ValidatorSet) proposerPowers() ([]int64, int64) {
+	var divisor int64
+	for _, v := range set.Validators {
+		a, b := v.VotingPower, divisor
+		for b != 0 {
+			a, b = b, a%b
+		}
+		divisor = a
+	}
+	if total := set.TotalPower() / divisor; total > maxProposerCycle {
+		divisor *= (total + maxProposerCycle - 1) / maxProposerCycle
+	}
+
+	powers := make([]int64, len(set.Validators))
+	var total int64
+	for i, v := range set.Validators {
+		powers[i] = v.VotingPower / divisor
+		if powers[i] == 0 {
+			powers[i] = 1
+		}
+		total += powers[i]
+	}
+
+	return powers, total
+}
+
+// Proposer returns the address of the proposer at the height and round.
+// The validators take turns in a smooth weighted round robin, so that over
+// each cycle every validator proposes in proportion to its voting power.
+func (set *ValidatorSet) Proposer(height, round int) string {
+	powers, total := set.proposerPowers()
+	turn := int64(height+round) % total
+
+	priorities := make([]int64, len(powers))
+	var proposer int
+	for i := int64(0); i <= turn; i++ {
+		proposer = 0
+		for j, power := range powers {
+			priorities[j] += power
+			if priorities[j] > priorities[proposer] {
+				proposer = j
+			}
+		}
+		priorities[proposer] -= total
+	}
+
+	return set.Validators[proposer].Address
+}
@@ -0,0 +1,100 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestLoadValidatorSet(t *testing.T) {
+	var entries []string
+	for i := 0; i < 7; i++ {
+		w := wlt.NewWallet()
+		entries = append(entries, fmt.Sprintf(`{"address": "localhost:40%02d", "pubkey": "%s", "power": %d}`, i, hex.EncodeToString(w.PublicKey), i+1))
+	}
+	path := writeTestManifest(t, "validators.json", fmt.Sprintf(`{"validators": [%s]}`, strings.Join(entries, ",")))
+
+	set, err := LoadValidatorSet(path)
+	assert.Nil(t, err)
+	assert.Len(t, set.Validators, 7)
+	assert.Equal(t, int64(28), set.TotalPower())
+	assert.Equal(t, int64(3), set.Power("localhost:4002"))
+	assert.Equal(t, int64(0), set.Power("localhost:3000"))
+
+	for _, invalid := range []string{
+		`{"validators": []}`,
+		`{"validators": [{"address": "localhost:3000", "power": 1}, {"address": "localhost:3000", "power": 1}]}`,
+		`{"validators": [{"address": "localhost:3000", "power": 0}]}`,
+		`{"validators": [{"address": "localhost:3000", "pubkey": "abcd", "power": 1}]}`,
+		`{"validators": [{"pubkey": "", "power": 1}]}`,
+		`[]`,
+	} {
+		_, err = LoadValidatorSet(writeTestManifest(t, "validators.json", invalid))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestValidatorSetQuorum(t *testing.T) {
+	for _, c := range []struct {
+		validators       int
+		quorum, oneThird int64
+	}{
+		{4, 3, 2},
+		{7, 5, 3},
+		{10, 7, 4},
+	} {
+		set := &ValidatorSet{}
+		for i := 0; i < c.validators; i++ {
+			set.Validators = append(set.Validators, Validator{fmt.Sprintf("localhost:%d", 3000+i), "", 1})
+		}
+		assert.Equal(t, c.quorum, set.Quorum(), "%d validators", c.validators)
+		assert.Equal(t, c.oneThird, set.OneThird(), "%d validators", c.validators)
+	}
+
+	// a quorum needs more than 2/3 of the power, not of the validators
+	weighted := &ValidatorSet{[]Validator{{"a", "", 5}, {"b", "", 1}, {"c", "", 1}, {"d", "", 2}}}
+	assert.Equal(t, int64(7), weighted.Quorum())
+}
+
+func TestProposer(t *testing.T) {
+	// equal powers take turns in order
+	set := DefaultValidatorSet()
+	for height := 0; height < 8; height++ {
+		for round := 0; round < 4; round++ {
+			assert.Equal(t, fmt.Sprintf("localhost:300%d", (height+round)%4), set.Proposer(height, round))
+		}
+	}
+
+	// and each validator proposes as often as its power over a cycle,
+	// without proposing many times in a row
+	weighted := &ValidatorSet{[]Validator{{"a", "", 3}, {"b", "", 1}, {"c", "", 2}}}
+	var turns []string
+	counts := make(map[string]int)
+	for height := 0; height < 12; height++ {
+		proposer := weighted.Proposer(height, 0)
+		turns = append(turns, proposer)
+		counts[proposer]++
+	}
+	assert.Equal(t, map[string]int{"a": 6, "b": 2, "c": 4}, counts)
+	assert.Equal(t, turns[:6], turns[6:])
+	assert.Equal(t, "a", weighted.Proposer(0, 0))
+	assert.Equal(t, weighted.Proposer(2, 3), weighted.Proposer(5, 0))
+	assert.NotEqual(t, turns[0], turns[1])
+
+	// large powers are scaled down, keeping their proportions
+	large := &ValidatorSet{[]Validator{{"a", "", 3 << 40}, {"b", "", 1 << 40}, {"c", "", 2 << 40}}}
+	for height := 0; height < 12; height++ {
+		assert.Equal(t, turns[height], large.Proposer(height, 0))
+	}
+	huge := &ValidatorSet{[]Validator{{"a", "", 1 << 61}, {"b", "", 1<<61 + 1}, {"c", "", 1}}}
+	counts = make(map[string]int)
+	for height := 0; height < maxProposerCycle; height++ {
+		counts[huge.Proposer(height, 0)]++
+	}
+	assert.InDelta(t, counts["a"], counts["b"], 1)
+	assert.Equal(t, 1, counts["c"])
+}
@@ -0,0 +1,64 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	wlt "github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+func TestValidateTransaction(t *testing.T) {
+	owner, holder := wlt.NewWallet(), wlt.NewWallet()
+	bc := newTestBlockchain(t, owner)
+	urpo := URPOSet{bc}
+	genesis := bc.Iterator().Next().Transactions[0]
+	holderAddress := fmt.Sprintf("%s", holder.GetAddress())
+
+	deposit := Deposit(owner, holderAddress, &urpo, Outpoint{genesis.ID, 0})
+	assert.Nil(t, bc.ValidateTransaction(deposit))
+
+	// the same tokoin cannot be spent twice in a block
+	edit := EditPolicy(*owner, &urpo, Outpoint{genesis.ID, 0}, "", "", "", "..8", "", "")
+	assert.Nil(t, bc.ValidateTransaction(edit))
+	_, err := bc.MineBlock([]*Transaction{deposit, edit})
+	assert.NotNil(t, err)
+
+	// nor an input twice in a transaction
+	doubled := *edit
+	doubled.Vin = append(doubled.Vin, edit.Vin[0])
+	assert.NotNil(t, bc.ValidateTransaction(&doubled))
+
+	// nor can a transaction be altered without changing its ID
+	altered := *edit
+	altered.Vout = []TXOutput{edit.Vout[0]}
+	altered.Vout[0].Temperature++
+	assert.NotNil(t, bc.ValidateTransaction(&altered))
+
+	mineTestBlock(t, bc, deposit)
+
+	// nor can a transaction already on the chain be included again
+	assert.NotNil(t, bc.ValidateTransaction(genesis))
+
+	// nor again once it is spent
+	assert.NotNil(t, bc.ValidateTransaction(edit))
+
+	// the new tokoin can be redeemed by the holder
+	ctx := RedeemContext{Temperature: 37}
+	redeem := RedeemTokoin(*holder, fmt.Sprintf("%s", owner.GetAddress()), &urpo, Outpoint{deposit.ID, 0}, &ctx)
+	assert.Nil(t, bc.ValidateTransaction(redeem))
+
+	// an unknown tokoin cannot be spent
+	unknown := *redeem
+	unknown.Vin = []TXInput{{[]byte("missing"), 0, redeem.Vin[0].Signature, redeem.Vin[0].PubKey, nil}}
+	assert.NotNil(t, bc.ValidateTransaction(&unknown))
+
+	// and non-coinbase transactions cannot create tokoins
+	create := *redeem
+	create.Type = OpCreate
+	assert.NotNil(t, bc.ValidateTransaction(&create))
+
+	block := newTestBlock(t, bc, bc.tip, redeem, &create)
+	assert.NotNil(t, bc.AddBlock(block))
+	assert.Equal(t, 1, bc.GetBestHeight())
+}
@@ -4,9 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"os"
+
+	bc "github.com/zhuaiballl/Go-Tokoin/blockchain"
 )
 
 // CLI responsible for processing command line arguments
@@ -14,19 +17,45 @@ type CLI struct{}
 
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  createtokoin -address ADDRESS - create a tokoin for ADDRESS")
-	fmt.Println("  editpolicy -address ADDRESS -txid TXID -time TIME -id ID -gps GPS -temperature TEMPERATURE - edit parameters for a tokoin ")
+	fmt.Println("  createtokoin -address ADDRESS [-uses N] - create a tokoin for ADDRESS, optionally redeemable N times")
+	fmt.Println("      -owners A,B,C -threshold M instead of -address creates a tokoin owned by M of the owners")
+	fmt.Println("  createtokoins -manifest FILE - create a batch of tokoins in one transaction from a JSON or CSV manifest,")
+	fmt.Println("      each row has owner, holder, time, id, gps, temperature, expiryheight, expirytime and uses fields")
+	fmt.Println("  editpolicy -address ADDRESS -txid TXID[:VOUT] -time TIME -id ID -gps GPS -temperature TEMPERATURE - edit parameters for a tokoin ")
+	fmt.Println("      a single value sets an exact match, MIN..MAX (either bound optional) sets a range,")
+	fmt.Println("      -time accepts comma-separated START..END windows and -id a comma-separated allow-list,")
+	fmt.Println("      -gps accepts LAT,LON, a circle LAT,LON,RADIUS (metres) or a polygon LAT,LON;LAT,LON;LAT,LON,")
+	fmt.Println("      -expiryheight HEIGHT and -expirytime UNIXTIME set or extend the expiry, 0 removes it")
 	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
 	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
 	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
+	fmt.Println("  getblock -height HEIGHT | -hash HASH - Print the block at HEIGHT on the main chain or the block with HASH")
 	fmt.Println("  reindexurpo - Rebuilds the URPO set")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  reindextx - Rebuilds the transaction index")
+	fmt.Println("  startnode -miner ADDRESS [-consensus pow|tendermint|dev] [-validators FILE] - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("      -validators is the JSON file of the Tendermint validators, required by tendermint, the default: {\"validators\": [{\"address\", \"pubkey\", \"power\"}, ...]}")
+	fmt.Println("      a validator signs its messages with the wallet of its pubkey")
 	fmt.Println("  listtokoins -address ADDRESS - List all tokoins belonging to ADDRESS")
-	fmt.Println("  deposit -address ADDRESS -holder HOLDER -txid TXID - set a holder for a tokoin")
-	fmt.Println("  revocat -address ADDRESS -txid TXID - revocat a tokoin")
-	fmt.Println("  redeem -holder HOLDER -owner OWNER -txid TXID -time TIME -id ID -gps GPS -temper TEMPERATURE - redeem a tokoin with the holder address and current condition")
-	fmt.Println("  test -flag FLAG -owner OWNER -holder HOLDER -txid TXID -time TIME -id ID -gps GPS -temper TEMPERATURE")
+	fmt.Println("      -holder HOLDER instead of -address lists all tokoins held by HOLDER")
+	fmt.Println("  history -txid TXID[:VOUT] - show every operation on a tokoin from its creation, with the signers and the changed conditions")
+	fmt.Println("  deposit -address ADDRESS -holder HOLDER -txid TXID[:VOUT] - set a holder for a tokoin")
+	fmt.Println("  transfer -holder HOLDER -to ADDRESS -txid TXID[:VOUT] - transfer a held tokoin to a new holder")
+	fmt.Println("  delegate -holder HOLDER -to ADDRESS -txid TXID[:VOUT] [-expiryheight HEIGHT] [-expirytime UNIXTIME] - lend a held tokoin to ADDRESS until the expiry")
+	fmt.Println("  revocat -address ADDRESS -txid TXID[:VOUT] - revocat a tokoin")
+	fmt.Println("  revokeholder -owner OWNER [-signer ADDRESS] -holder HOLDER - revoke all tokoins of OWNER held by HOLDER and bar HOLDER from them,")
+	fmt.Println("      -signer is one of the owners of a multi-signature OWNER")
+	fmt.Println("  partialsign -address ADDRESS -tx FILE - add the signature of an owner to a multi-signature transaction")
+	fmt.Println("  combine -tx FILE1,FILE2,... [-out FILE] - combine the signatures of copies of a multi-signature transaction")
+	fmt.Println("      owner operations on a multi-signature tokoin are saved to a file until enough owners have signed")
+	fmt.Println("  buildtx -op OP -address SIGNER [-out FILE] ... - build an unsigned transaction to be signed by SIGNER and save it to FILE,")
+	fmt.Println("      OP is deposit, transfer, delegate, edit, revocat, revokeholder or redeem and takes the flags of the matching command")
+	fmt.Println("  signtx [-address ADDRESS] FILE - sign the transaction in FILE with the wallet key of ADDRESS, or with every wallet key that can sign it")
+	fmt.Println("  inspecttx FILE - show the transaction in FILE, the tokoins it spends and the state of its signatures")
+	fmt.Println("  broadcasttx FILE - hand in the fully signed transaction in FILE")
+	fmt.Println("  redeem -holder HOLDER -owner OWNER -txid TXID[:VOUT] -time TIME -id ID -gps GPS -temper TEMPERATURE - redeem a held tokoin, signed by the holder, with the current condition")
+	fmt.Println("  test -flag FLAG -owner OWNER -holder HOLDER -txid TXID[:VOUT] -time TIME -id ID -gps GPS -temper TEMPERATURE")
+	fmt.Println("  a tokoin is addressed as TXID[:VOUT], the output VOUT of transaction TXID, VOUT defaults to 0")
 }
 
 func (cli *CLI) validateArgs() {
@@ -47,48 +76,105 @@ func (cli *CLI) Run() {
 	}
 
 	createTokoinCmd := flag.NewFlagSet("createtokoin", flag.ExitOnError)
+	createTokoinsCmd := flag.NewFlagSet("createtokoins", flag.ExitOnError)
 	editPolicyCmd := flag.NewFlagSet("editpolicy", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
 	reindexURPOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 	listTokoinsCmd := flag.NewFlagSet("listtokoins", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
 	depositCmd := flag.NewFlagSet("deposit", flag.ExitOnError)
+	transferCmd := flag.NewFlagSet("transfer", flag.ExitOnError)
+	delegateCmd := flag.NewFlagSet("delegate", flag.ExitOnError)
 	revocatCmd := flag.NewFlagSet("revocat", flag.ExitOnError)
+	revokeHolderCmd := flag.NewFlagSet("revokeholder", flag.ExitOnError)
 	redeemCmd := flag.NewFlagSet("redeem", flag.ExitOnError)
+	partialSignCmd := flag.NewFlagSet("partialsign", flag.ExitOnError)
+	combineCmd := flag.NewFlagSet("combine", flag.ExitOnError)
+	buildTxCmd := flag.NewFlagSet("buildtx", flag.ExitOnError)
+	signTxCmd := flag.NewFlagSet("signtx", flag.ExitOnError)
+	inspectTxCmd := flag.NewFlagSet("inspecttx", flag.ExitOnError)
+	broadcastTxCmd := flag.NewFlagSet("broadcasttx", flag.ExitOnError)
 	testCmd := flag.NewFlagSet("test", flag.ExitOnError)
 
 	createTokoinAddress := createTokoinCmd.String("address", "", "The address to mint")
+	createTokoinUses := createTokoinCmd.Int("uses", 0, "The number of redemptions allowed, 0 for unlimited")
+	createTokoinOwners := createTokoinCmd.String("owners", "", "The comma-separated addresses of the owners of a multi-signature tokoin")
+	createTokoinThreshold := createTokoinCmd.Int("threshold", 0, "The number of owners needed to sign for a multi-signature tokoin")
+	createTokoinsManifest := createTokoinsCmd.String("manifest", "", "The JSON or CSV manifest of the tokoins to mint")
 	editPolicyAddress := editPolicyCmd.String("address", "", "The address to edit")
-	editPolicyTxId := editPolicyCmd.String("txid", "", "The txid of the edited tokoin")
-	editPolicyTime := editPolicyCmd.String("time", "", "The new time for the tokoin")
-	editPolicyId := editPolicyCmd.String("id", "", "The new ID for the tokoin")
-	editPolicyGPS := editPolicyCmd.String("gps", "", "The new GPS for the tokoin")
-	editPolicyTemper := editPolicyCmd.String("temperature", "", "The new temperature for the tokoin")
+	editPolicyTxId := editPolicyCmd.String("txid", "", "The txid[:vout] of the edited tokoin")
+	editPolicyTime := editPolicyCmd.String("time", "", "The new time or time windows (START..END,...) for the tokoin")
+	editPolicyId := editPolicyCmd.String("id", "", "The new ID or ID allow-list (ID1,ID2,...) for the tokoin")
+	editPolicyGPS := editPolicyCmd.String("gps", "", "The new position (LAT,LON) or geofence (LAT,LON,RADIUS or LAT,LON;LAT,LON;...) for the tokoin")
+	editPolicyTemper := editPolicyCmd.String("temperature", "", "The new temperature or temperature range (MIN..MAX) for the tokoin")
+	editPolicyExpiryHeight := editPolicyCmd.String("expiryheight", "", "The last block height at which the tokoin can be used")
+	editPolicyExpiryTime := editPolicyCmd.String("expirytime", "", "The last unix time at which the tokoin can be used")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	getBlockHeight := getBlockCmd.Int("height", -1, "The height of the block on the main chain")
+	getBlockHash := getBlockCmd.String("hash", "", "The hash of the block")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeConsensus := startNodeCmd.String("consensus", bc.DefaultConsensus, "The consensus engine: pow, tendermint or dev")
+	startNodeValidators := startNodeCmd.String("validators", "", "The genesis validator set file, required by tendermint")
 	listTokoinsAddress := listTokoinsCmd.String("address", "", "The address to list tokoins for")
+	listTokoinsHolder := listTokoinsCmd.String("holder", "", "The holder address to list held tokoins for")
+	historyTxId := historyCmd.String("txid", "", "The txid[:vout] of the tokoin")
 	depositAddress := depositCmd.String("address", "", "The address of tokoin holder")
 	depositHolder := depositCmd.String("holder", "", "The address of tokoin holder")
-	depositTxId := depositCmd.String("txid", "", "The txid of the deposited tokoin")
+	depositTxId := depositCmd.String("txid", "", "The txid[:vout] of the deposited tokoin")
+	transferHolder := transferCmd.String("holder", "", "The address of the current tokoin holder")
+	transferTo := transferCmd.String("to", "", "The address of the new tokoin holder")
+	transferTxId := transferCmd.String("txid", "", "The txid[:vout] of the transferred tokoin")
+	delegateHolder := delegateCmd.String("holder", "", "The address of the current tokoin holder")
+	delegateTo := delegateCmd.String("to", "", "The address the tokoin is delegated to")
+	delegateTxId := delegateCmd.String("txid", "", "The txid[:vout] of the delegated tokoin")
+	delegateExpiryHeight := delegateCmd.String("expiryheight", "", "The last block height at which the delegation can be used")
+	delegateExpiryTime := delegateCmd.String("expirytime", "", "The last unix time at which the delegation can be used")
 	revocatAddress := revocatCmd.String("address", "", "The address of the tokoin owner")
-	revocatTxId := revocatCmd.String("txid", "", "The txid of the revocated tokoin")
-	redeemHolder := redeemCmd.String("address", "", "The address of the tokoin holder")
+	revocatTxId := revocatCmd.String("txid", "", "The txid[:vout] of the revocated tokoin")
+	revokeHolderOwner := revokeHolderCmd.String("owner", "", "The address of the tokoin owner")
+	revokeHolderSigner := revokeHolderCmd.String("signer", "", "The address of the signing owner of a multi-signature owner")
+	revokeHolderHolder := revokeHolderCmd.String("holder", "", "The address of the revoked holder")
+	partialSignAddress := partialSignCmd.String("address", "", "The address of the signing owner")
+	partialSignTx := partialSignCmd.String("tx", "", "The file of the transaction to sign")
+	combineTx := combineCmd.String("tx", "", "The comma-separated files of the signed copies of a transaction")
+	combineOut := combineCmd.String("out", "", "The file to save the combined transaction to if it still lacks signatures")
+	buildTxOp := buildTxCmd.String("op", "", "The operation: deposit, transfer, delegate, edit, revocat, revokeholder or redeem")
+	buildTxAddress := buildTxCmd.String("address", "", "The address of the signer, the owner or the holder of the tokoin")
+	buildTxOut := buildTxCmd.String("out", "", "The file to save the unsigned transaction to")
+	buildTxTo := buildTxCmd.String("to", "", "The address of the new holder of a transfer or a delegation")
+	buildTxOwner := buildTxCmd.String("owner", "", "The address of the tokoin owner of a redemption or a holder revocation")
+	buildTxHolder := buildTxCmd.String("holder", "", "The address of the holder of a deposit or a holder revocation")
+	buildTxTxId := buildTxCmd.String("txid", "", "The txid[:vout] of the tokoin")
+	buildTxTime := buildTxCmd.String("time", "", "The time policy of an edit or the time condition of a redemption")
+	buildTxId := buildTxCmd.String("id", "", "The ID policy of an edit or the ID condition of a redemption")
+	buildTxGPS := buildTxCmd.String("gps", "", "The GPS policy of an edit or the GPS position of a redemption")
+	buildTxTemper := buildTxCmd.String("temperature", "", "The temperature policy of an edit or the temperature condition of a redemption")
+	buildTxExpiryHeight := buildTxCmd.String("expiryheight", "", "The expiry height of an edit or a delegation")
+	buildTxExpiryTime := buildTxCmd.String("expirytime", "", "The expiry unix time of an edit or a delegation")
+	signTxAddress := signTxCmd.String("address", "", "The address of the signing key, all signing keys of the wallet if empty")
+	signTxFile := signTxCmd.String("tx", "", "The file of the transaction to sign")
+	inspectTxFile := inspectTxCmd.String("tx", "", "The file of the transaction to inspect")
+	broadcastTxFile := broadcastTxCmd.String("tx", "", "The file of the transaction to broadcast")
+	redeemHolder := redeemCmd.String("holder", "", "The address of the tokoin holder")
 	redeemOwner := redeemCmd.String("owner", "", "The address of the tokoin owner")
-	redeemTxId := redeemCmd.String("txid", "", "The txid of the redeemed tokoin")
+	redeemTxId := redeemCmd.String("txid", "", "The txid[:vout] of the redeemed tokoin")
 	redeemTime := redeemCmd.String("time", "", "The time condition of redemption")
 	redeemId := redeemCmd.String("id", "", "The ID condition of redemption")
-	redeemGPS := redeemCmd.String("gps", "", "The GPS condition of redemption")
+	redeemGPS := redeemCmd.String("gps", "", "The GPS position (LAT,LON) of redemption, optionally with an accuracy radius (LAT,LON,RADIUS)")
 	redeemTemper := redeemCmd.String("temper", "", "The temperature condition of redemption")
 	testFlag := testCmd.String("flag", "", "The type of the test")
 	testOwner := testCmd.String("owner", "", "The owner of the tokoin")
 	testHolder := testCmd.String("holder", "", "The holder of the tokoin")
-	testTxid := testCmd.String("txid", "", "The txid of the tokoin")
+	testTxid := testCmd.String("txid", "", "The txid[:vout] of the tokoin")
 	testTime := testCmd.String("time", "", "The target/current time condition")
 	testID := testCmd.String("id", "", "The tartget/current ID condition")
-	testGPS := testCmd.String("gps", "", "The target/current GPS condition")
+	testGPS := testCmd.String("gps", "", "The target/current GPS condition (LAT,LON or LAT,LON,RADIUS)")
 	testTemper := testCmd.String("temper", "", "The target/current temperature condition")
 
 	fmt.Println("cli in - ", time.Now())
@@ -113,11 +199,21 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getblock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "reindexurpo":
 		err := reindexURPOCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "startnode":
 		err := startNodeCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -128,6 +224,11 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "createtokoins":
+		err := createTokoinsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "editpolicy":
 		err := editPolicyCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -138,21 +239,71 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "deposit":
 		err := depositCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "transfer":
+		err := transferCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "delegate":
+		err := delegateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "revocat":
 		err := revocatCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "revokeholder":
+		err := revokeHolderCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "redeem":
 		err := redeemCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "partialsign":
+		err := partialSignCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "combine":
+		err := combineCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "buildtx":
+		err := buildTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signtx":
+		err := signTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "inspecttx":
+		err := inspectTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "broadcasttx":
+		err := broadcastTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "test":
 		err := testCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -183,25 +334,55 @@ func (cli *CLI) Run() {
 		cli.printChain(nodeID)
 	}
 
+	if getBlockCmd.Parsed() {
+		if (*getBlockHeight < 0) == (*getBlockHash == "") {
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(*getBlockHeight, *getBlockHash, nodeID)
+	}
+
 	if reindexURPOCmd.Parsed() {
 		cli.reindexURPO(nodeID)
 	}
 
+	if reindexTxCmd.Parsed() {
+		cli.reindexTx(nodeID)
+	}
+
 	if startNodeCmd.Parsed() {
 		nodeID := os.Getenv("NODE_ID")
 		if nodeID == "" {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		// the validators of Tendermint must have keys, the default ones have none
+		if *startNodeConsensus == "tendermint" && *startNodeValidators == "" {
+			fmt.Println("-validators is required by tendermint")
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.startNode(nodeID, *startNodeMiner, *startNodeConsensus, *startNodeValidators)
 	}
 
 	if createTokoinCmd.Parsed() {
-		if *createTokoinAddress == "" {
+		var owners []string
+		if *createTokoinOwners != "" {
+			owners = strings.Split(*createTokoinOwners, ",")
+		}
+		if (*createTokoinAddress == "") == (len(owners) == 0) || *createTokoinUses < 0 {
 			createTokoinCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createTokoin(*createTokoinAddress, nodeID)
+		cli.createTokoin(*createTokoinAddress, owners, *createTokoinThreshold, *createTokoinUses, nodeID)
+	}
+
+	if createTokoinsCmd.Parsed() {
+		if *createTokoinsManifest == "" {
+			createTokoinsCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createTokoins(*createTokoinsManifest, nodeID)
 	}
 
 	if editPolicyCmd.Parsed() {
@@ -209,15 +390,23 @@ func (cli *CLI) Run() {
 			editPolicyCmd.Usage()
 			os.Exit(1)
 		}
-		cli.editPolicy(*editPolicyAddress, *editPolicyTxId, nodeID, *editPolicyTime, *editPolicyId, *editPolicyGPS, *editPolicyTemper)
+		cli.editPolicy(*editPolicyAddress, *editPolicyTxId, nodeID, *editPolicyTime, *editPolicyId, *editPolicyGPS, *editPolicyTemper, *editPolicyExpiryHeight, *editPolicyExpiryTime)
 	}
 
 	if listTokoinsCmd.Parsed() {
-		if *listTokoinsAddress == "" {
+		if (*listTokoinsAddress == "") == (*listTokoinsHolder == "") {
 			listTokoinsCmd.Usage()
 			os.Exit(1)
 		}
-		cli.listTokoins(*listTokoinsAddress, nodeID)
+		cli.listTokoins(*listTokoinsAddress, *listTokoinsHolder, nodeID)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyTxId == "" {
+			historyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.history(*historyTxId, nodeID)
 	}
 
 	if depositCmd.Parsed() {
@@ -228,6 +417,22 @@ func (cli *CLI) Run() {
 		cli.deposit(*depositAddress, *depositHolder, *depositTxId, nodeID)
 	}
 
+	if transferCmd.Parsed() {
+		if *transferHolder == "" || *transferTo == "" || *transferTxId == "" {
+			transferCmd.Usage()
+			os.Exit(1)
+		}
+		cli.transfer(*transferHolder, *transferTo, *transferTxId, nodeID)
+	}
+
+	if delegateCmd.Parsed() {
+		if *delegateHolder == "" || *delegateTo == "" || *delegateTxId == "" || (*delegateExpiryHeight == "" && *delegateExpiryTime == "") {
+			delegateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.delegate(*delegateHolder, *delegateTo, *delegateTxId, nodeID, *delegateExpiryHeight, *delegateExpiryTime)
+	}
+
 	if revocatCmd.Parsed() {
 		if *revocatAddress == "" || *revocatTxId == "" {
 			revocatCmd.Usage()
@@ -236,6 +441,52 @@ func (cli *CLI) Run() {
 		cli.revocat(*revocatAddress, *revocatTxId, nodeID)
 	}
 
+	if revokeHolderCmd.Parsed() {
+		if *revokeHolderOwner == "" || *revokeHolderHolder == "" {
+			revokeHolderCmd.Usage()
+			os.Exit(1)
+		}
+		cli.revokeHolder(*revokeHolderOwner, *revokeHolderSigner, *revokeHolderHolder, nodeID)
+	}
+
+	if partialSignCmd.Parsed() {
+		if *partialSignAddress == "" || *partialSignTx == "" {
+			partialSignCmd.Usage()
+			os.Exit(1)
+		}
+		cli.partialSign(*partialSignAddress, *partialSignTx, nodeID)
+	}
+
+	if combineCmd.Parsed() {
+		if *combineTx == "" {
+			combineCmd.Usage()
+			os.Exit(1)
+		}
+		cli.combine(*combineTx, *combineOut, nodeID)
+	}
+
+	if buildTxCmd.Parsed() {
+		if *buildTxOp == "" || *buildTxAddress == "" {
+			buildTxCmd.Usage()
+			os.Exit(1)
+		}
+		params := txParams{*buildTxTo, *buildTxOwner, *buildTxHolder, *buildTxTxId, *buildTxTime, *buildTxId, *buildTxGPS, *buildTxTemper, *buildTxExpiryHeight, *buildTxExpiryTime}
+		cli.buildTx(*buildTxOp, *buildTxAddress, params, *buildTxOut, nodeID)
+	}
+
+	if signTxCmd.Parsed() {
+		txFile := txFileArg(signTxCmd, *signTxFile)
+		cli.signTx(*signTxAddress, txFile, nodeID)
+	}
+
+	if inspectTxCmd.Parsed() {
+		cli.inspectTx(txFileArg(inspectTxCmd, *inspectTxFile))
+	}
+
+	if broadcastTxCmd.Parsed() {
+		cli.broadcastTx(txFileArg(broadcastTxCmd, *broadcastTxFile))
+	}
+
 	if redeemCmd.Parsed() {
 		if *redeemHolder == "" || *redeemOwner == "" || *redeemTxId == "" {
 			redeemCmd.Usage()
@@ -250,3 +501,16 @@ func (cli *CLI) Run() {
 
 	fmt.Println("cli out - ", time.Now())
 }
+
+// txFileArg returns the transaction file given by -tx or as the first argument
+func txFileArg(cmd *flag.FlagSet, txFile string) string {
+	if txFile == "" {
+		txFile = cmd.Arg(0)
+	}
+	if txFile == "" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	return txFile
+}
@@ -9,11 +9,12 @@ import (
 	"github.com/zhuaiballl/Go-Tokoin/wallet"
 	"log"
 	"strconv"
+	"time"
 )
 
 func (cli *CLI) test(nodeID, flag, owner, holder, txId, time, id, gps, temper string) {
 	bchain := bc.NewBlockchain(nodeID)
-	URPOSet := bc.URPOSet{bchain}
+	URPOSet := bc.URPOSet{Blockchain: bchain}
 	defer bchain.CloseDB()
 
 	wallets, err := wallet.NewWallets(nodeID)
@@ -21,11 +22,14 @@ func (cli *CLI) test(nodeID, flag, owner, holder, txId, time, id, gps, temper st
 		log.Panic(err)
 	}
 
-	txID, err := hex.DecodeString(txId)
+	outpoint, err := bc.ParseOutpoint(txId)
+	if err != nil {
+		log.Panic(err)
+	}
+	output, err := URPOSet.FindOutput(outpoint)
 	if err != nil {
 		log.Panic(err)
 	}
-	output := URPOSet.FindOutput(txID)
 
 	switch flag {
 	case "id_check":
@@ -45,11 +49,8 @@ func (cli *CLI) test(nodeID, flag, owner, holder, txId, time, id, gps, temper st
 		}
 	case "ref_check":
 		{
-			cTime, _ := strconv.Atoi(time)
-			cId := []byte(id)
-			cGPS, _ := strconv.Atoi(gps)
-			cTemper, _ := strconv.Atoi(temper)
-			if output.CheckCondition(&cTime, &cId, &cGPS, &cTemper) {
+			ctx := newRedeemContext(time, id, gps, temper)
+			if output.CheckCondition(ctx) {
 				fmt.Println("pass")
 			} else {
 				fmt.Println("fail")
@@ -57,7 +58,7 @@ func (cli *CLI) test(nodeID, flag, owner, holder, txId, time, id, gps, temper st
 		}
 	case "modify_access_output":
 		{
-			tx := bc.EditPolicy(wallets.GetWallet(owner), &URPOSet, txID, time, id, gps, temper)
+			tx := bc.EditPolicy(wallets.GetWallet(owner), &URPOSet, outpoint, time, id, gps, temper, "", "")
 			bc.HandinTx(tx)
 			fmt.Println("Success!")
 		}
@@ -73,13 +74,16 @@ func (cli *CLI) createBlockchain(address, nodeID string) {
 	bchain := bc.CreateBlockchain(address, nodeID)
 	defer bchain.CloseDB()
 
-	URPOSet := bc.URPOSet{bchain}
+	URPOSet := bc.URPOSet{Blockchain: bchain}
 	URPOSet.Reindex()
 
 	fmt.Println("Done!")
 }
 
-func (cli *CLI) createTokoin(address, nodeID string) {
+func (cli *CLI) createTokoin(address string, owners []string, threshold, uses int, nodeID string) {
+	if len(owners) > 0 {
+		address = owners[0]
+	}
 	if !wallet.ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
@@ -89,8 +93,16 @@ func (cli *CLI) createTokoin(address, nodeID string) {
 
 	//tx := NewURPOTransaction(&wallet, to, &URPOSet)
 
-	cbTx := bc.NewCoinbaseTX(address, "", 0, nil, 0, 37)
-	go bc.StartServer(nodeID, "")
+	cbTx := bc.NewCoinbaseTX(address, "", 0, nil, bc.Coordinate{}, 37, uses)
+	if len(owners) > 0 {
+		out := cbTx.Vout[0]
+		err := out.LockMultisig(owners, threshold)
+		if err != nil {
+			log.Panic(err)
+		}
+		cbTx = bc.NewIssuanceTX("", []bc.TXOutput{out})
+		fmt.Printf("Multi-signature owner address: %s\n", wallet.KeyHashToAddress(out.PubKeyHash))
+	}
 	bc.HandinTx(cbTx)
 	//txs := []*Transaction{cbTx}//, tx}
 	//
@@ -101,6 +113,26 @@ func (cli *CLI) createTokoin(address, nodeID string) {
 	fmt.Println("Success!")
 }
 
+func (cli *CLI) createTokoins(manifest, nodeID string) {
+	outputs, err := bc.ReadManifest(manifest)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx := bc.NewIssuanceTX("", outputs)
+	bc.HandinTx(tx)
+
+	fmt.Printf("Transaction %x\n", tx.ID)
+	for i, out := range tx.Vout {
+		fmt.Printf("  %s: owner %x", bc.Outpoint{Txid: tx.ID, Vout: i}, out.PubKeyHash)
+		if len(out.HolderKey) > 0 {
+			fmt.Printf(", holder %x", out.HolderKey)
+		}
+		fmt.Println()
+	}
+	fmt.Println("Success!")
+}
+
 func (cli *CLI) createWallet(nodeID string) {
 	wallets, _ := wallet.NewWallets(nodeID)
 	address := wallets.CreateWallet()
@@ -110,6 +142,36 @@ func (cli *CLI) createWallet(nodeID string) {
 	fmt.Printf("Your new address: %s\n", address)
 }
 
+func (cli *CLI) delegate(holder, to, txId, nodeID, expiryHeight, expiryTime string) {
+	if !wallet.ValidateAddress(holder) {
+		log.Panic("ERROR: Holder address is not valid")
+	}
+	if !wallet.ValidateAddress(to) {
+		log.Panic("ERROR: Delegate address is not valid")
+	}
+	bchain := bc.NewBlockchain(nodeID)
+	URPOSet := bc.URPOSet{Blockchain: bchain}
+	defer bchain.CloseDB()
+
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(holder)
+
+	outpoint, err := bc.ParseOutpoint(txId)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx := bc.Delegate(wallet, to, &URPOSet, outpoint, expiryHeight, expiryTime)
+
+	bc.HandinTx(tx)
+
+	fmt.Printf("Delegated tokoin: %s\n", bc.Outpoint{Txid: tx.ID, Vout: 1})
+	fmt.Println("Success!")
+}
+
 func (cli *CLI) deposit(address, holder, txId string, nodeID string) { //wallet *Wallet, holder string, URPOSet *URPOSet,txId []byte, out int
 	if !wallet.ValidateAddress(address) {
 		log.Panic("ERROR: Sender address is not valid")
@@ -118,7 +180,7 @@ func (cli *CLI) deposit(address, holder, txId string, nodeID string) { //wallet
 		log.Panic("ERROR: Holder address is not valid")
 	}
 	bchain := bc.NewBlockchain(nodeID)
-	URPOSet := bc.URPOSet{bchain}
+	URPOSet := bc.URPOSet{Blockchain: bchain}
 	defer bchain.CloseDB()
 
 	wallets, err := wallet.NewWallets(nodeID)
@@ -127,19 +189,22 @@ func (cli *CLI) deposit(address, holder, txId string, nodeID string) { //wallet
 	}
 	wallet := wallets.GetWallet(address)
 
-	tx := bc.Deposit(&wallet, holder, &URPOSet, txId)
+	outpoint, err := bc.ParseOutpoint(txId)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	bc.HandinTx(tx)
+	tx := bc.Deposit(&wallet, holder, &URPOSet, outpoint)
 
-	fmt.Println("Success!")
+	submitTx(bchain, tx, "")
 }
 
-func (cli *CLI) editPolicy(address, txId, nodeID, time, id, gps, temper string) {
+func (cli *CLI) editPolicy(address, txId, nodeID, time, id, gps, temper, expiryHeight, expiryTime string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("ERROR: Sender address is not valid")
 	}
 	bchain := bc.NewBlockchain(nodeID)
-	URPOSet := bc.URPOSet{bchain}
+	URPOSet := bc.URPOSet{Blockchain: bchain}
 	defer bchain.CloseDB()
 
 	wallets, err := wallet.NewWallets(nodeID)
@@ -148,14 +213,49 @@ func (cli *CLI) editPolicy(address, txId, nodeID, time, id, gps, temper string)
 	}
 	wallet := wallets.GetWallet(address)
 
-	txID, err := hex.DecodeString(txId)
+	outpoint, err := bc.ParseOutpoint(txId)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	tx := bc.EditPolicy(wallet, &URPOSet, txID, time, id, gps, temper)
-	bc.HandinTx(tx)
-	fmt.Println("Success!")
+	tx := bc.EditPolicy(wallet, &URPOSet, outpoint, time, id, gps, temper, expiryHeight, expiryTime)
+	submitTx(bchain, tx, "")
+}
+
+func (cli *CLI) history(txId, nodeID string) {
+	bchain := bc.NewBlockchain(nodeID)
+	URPOSet := bc.URPOSet{Blockchain: bchain}
+	defer bchain.CloseDB()
+
+	outpoint, err := bc.ParseOutpoint(txId)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	entries, err := bchain.TokoinHistory(outpoint)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("Height %d, %s: %s in transaction %x\n", entry.Height, time.Unix(entry.Timestamp, 0).Format(time.RFC3339), entry.Type, entry.TxID)
+		for _, signer := range entry.Signers {
+			fmt.Printf("  signed by %s\n", wallet.KeyHashToAddress(signer))
+		}
+		for _, change := range entry.Changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+
+	last := entries[len(entries)-1].Outpoint
+	switch {
+	case last == nil:
+		fmt.Println("The tokoin no longer exists")
+	case URPOSet.IsUnspent(*last):
+		fmt.Printf("The tokoin is now %s\n", last)
+	default:
+		fmt.Printf("The tokoin %s has expired\n", last)
+	}
 }
 
 func (cli *CLI) listAddresses(nodeID string) {
@@ -170,24 +270,38 @@ func (cli *CLI) listAddresses(nodeID string) {
 	}
 }
 
-func (cli *CLI) listTokoins(address, nodeID string) {
-	if !wallet.ValidateAddress(address) {
+func (cli *CLI) listTokoins(address, holder, nodeID string) {
+	if address != "" && !wallet.ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
+	if holder != "" && !wallet.ValidateAddress(holder) {
+		log.Panic("ERROR: Holder address is not valid")
+	}
 	bchain := bc.NewBlockchain(nodeID)
-	URPOSet := bc.URPOSet{bchain}
+	URPOSet := bc.URPOSet{Blockchain: bchain}
 	defer bchain.CloseDB()
 
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	txIDs := URPOSet.FindURPOIndexs(pubKeyHash)
-	outs := URPOSet.FindURPO(pubKeyHash)
-
-	for _, txID := range txIDs {
-		fmt.Println(txID)
+	var outpoints []string
+	var outs []bc.TXOutput
+	if holder != "" {
+		holderKey := utils.Base58Decode([]byte(holder))
+		holderKey = holderKey[1 : len(holderKey)-4]
+		outpoints = URPOSet.FindHeldURPOIndexs(holderKey)
+		outs = URPOSet.FindHeldURPO(holderKey)
+	} else {
+		pubKeyHash := utils.Base58Decode([]byte(address))
+		pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+		outpoints = URPOSet.FindURPOIndexs(pubKeyHash)
+		outs = URPOSet.FindURPO(pubKeyHash)
 	}
 
-	for _, out := range outs {
+	nextHeight := bchain.GetBestHeight() + 1
+	now := time.Now().Unix()
+	for i, out := range outs {
+		fmt.Println(outpoints[i])
+		if out.IsExpired(nextHeight, now) {
+			fmt.Println("(expired)")
+		}
 		out.Show()
 	}
 }
@@ -200,16 +314,7 @@ func (cli *CLI) printChain(nodeID string) {
 
 	for {
 		block := bci.Next()
-
-		fmt.Printf("============ Block %x ============\n", block.Hash)
-		fmt.Printf("Height: %d\n", block.Height)
-		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-		pow := bc.NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
-		}
-		fmt.Printf("\n\n")
+		printBlock(block)
 
 		if len(block.PrevBlockHash) == 0 {
 			break
@@ -217,6 +322,42 @@ func (cli *CLI) printChain(nodeID string) {
 	}
 }
 
+func printBlock(block *bc.Block) {
+	fmt.Printf("============ Block %x ============\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
+	fmt.Printf("Bits: %d\n", block.Bits)
+	pow := bc.NewProofOfWork(block)
+	fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+	fmt.Printf("\n\n")
+}
+
+func (cli *CLI) getBlock(height int, hash, nodeID string) {
+	bchain := bc.NewBlockchain(nodeID)
+	defer bchain.CloseDB()
+
+	var block bc.Block
+	var err error
+	if hash != "" {
+		var blockHash []byte
+		blockHash, err = hex.DecodeString(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		block, err = bchain.GetBlock(blockHash)
+	} else {
+		block, err = bchain.GetBlockByHeight(height)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	printBlock(&block)
+}
+
 func (cli *CLI) redeem(holder, owner, txId, nodeID, time, id, gps, temper string) {
 	if !wallet.ValidateAddress(holder) {
 		log.Panic("ERROR: Holder address is not valid")
@@ -225,46 +366,67 @@ func (cli *CLI) redeem(holder, owner, txId, nodeID, time, id, gps, temper string
 		log.Panic("ERROR: Owner address is not valid")
 	}
 	bchain := bc.NewBlockchain(nodeID)
-	URPOSet := bc.URPOSet{bchain}
+	URPOSet := bc.URPOSet{Blockchain: bchain}
 	defer bchain.CloseDB()
 
 	wallets, err := wallet.NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(owner)
+	wallet := wallets.GetWallet(holder)
 
-	txID, err := hex.DecodeString(txId)
+	outpoint, err := bc.ParseOutpoint(txId)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	cTime, _ := strconv.Atoi(time)
-	cId := []byte(id)
-	cGPS, _ := strconv.Atoi(gps)
-	cTemper, _ := strconv.Atoi(temper)
-	tx := bc.RedeemTokoin(wallet, holder, &URPOSet, txID, &cTime, &cId, &cGPS, &cTemper)
+	ctx := newRedeemContext(time, id, gps, temper)
+	tx := bc.RedeemTokoin(wallet, owner, &URPOSet, outpoint, ctx)
 
 	bc.HandinTx(tx)
 
 	fmt.Println("Success!")
 }
 
+func newRedeemContext(time, id, gps, temper string) *bc.RedeemContext {
+	cTime, _ := strconv.Atoi(time)
+	cTemper, _ := strconv.Atoi(temper)
+	var cGPS bc.Coordinate
+	var cAccuracy int
+	if gps != "" {
+		var err error
+		cGPS, cAccuracy, err = bc.ParsePosition(gps)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	return &bc.RedeemContext{Time: cTime, ID: []byte(id), GPS: cGPS, Accuracy: cAccuracy, Temperature: cTemper}
+}
+
 func (cli *CLI) reindexURPO(nodeID string) {
 	bchain := bc.NewBlockchain(nodeID)
-	URPOSet := bc.URPOSet{bchain}
+	URPOSet := bc.URPOSet{Blockchain: bchain}
 	URPOSet.Reindex()
 
 	count := URPOSet.CountTransactions()
 	fmt.Printf("Done! There are %d transactions in the URPO set.\n", count)
 }
 
+func (cli *CLI) reindexTx(nodeID string) {
+	bchain := bc.NewBlockchain(nodeID)
+	defer bchain.CloseDB()
+
+	count := bchain.ReindexTransactions()
+	fmt.Printf("Done! There are %d transactions in the transaction index.\n", count)
+}
+
 func (cli *CLI) revocat(address, txId, nodeID string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("ERROR: The address is not valid")
 	}
 	bchain := bc.NewBlockchain(nodeID)
-	URPOSet := bc.URPOSet{bchain}
+	URPOSet := bc.URPOSet{Blockchain: bchain}
 	defer bchain.CloseDB()
 
 	wallets, err := wallet.NewWallets(nodeID)
@@ -273,20 +435,95 @@ func (cli *CLI) revocat(address, txId, nodeID string) {
 	}
 	wallet := wallets.GetWallet(address)
 
-	txID, err := hex.DecodeString(txId)
+	outpoint, err := bc.ParseOutpoint(txId)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx := bc.RevocatTokoin(wallet, &URPOSet, outpoint)
+
+	submitTx(bchain, tx, "")
+}
+
+func (cli *CLI) revokeHolder(owner, signer, holder, nodeID string) {
+	if !wallet.ValidateAddress(owner) {
+		log.Panic("ERROR: Owner address is not valid")
+	}
+	if signer == "" {
+		signer = owner
+	}
+	if !wallet.ValidateAddress(signer) {
+		log.Panic("ERROR: Signer address is not valid")
+	}
+	if !wallet.ValidateAddress(holder) {
+		log.Panic("ERROR: Holder address is not valid")
+	}
+	bchain := bc.NewBlockchain(nodeID)
+	URPOSet := bc.URPOSet{Blockchain: bchain}
+	defer bchain.CloseDB()
+
+	wallets, err := wallet.NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
+	wallet := wallets.GetWallet(signer)
 
-	tx := bc.RevocatTokoin(wallet, &URPOSet, txID)
+	tx := bc.RevokeHolder(wallet, owner, holder, &URPOSet)
+
+	fmt.Printf("Revoking %d tokoins\n", len(tx.Vin))
+	submitTx(bchain, tx, "")
+}
+
+func (cli *CLI) transfer(holder, to, txId, nodeID string) {
+	if !wallet.ValidateAddress(holder) {
+		log.Panic("ERROR: Holder address is not valid")
+	}
+	if !wallet.ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+	bchain := bc.NewBlockchain(nodeID)
+	URPOSet := bc.URPOSet{Blockchain: bchain}
+	defer bchain.CloseDB()
+
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(holder)
+
+	outpoint, err := bc.ParseOutpoint(txId)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx := bc.Transfer(wallet, to, &URPOSet, outpoint)
 
 	bc.HandinTx(tx)
 
 	fmt.Println("Success!")
 }
 
-func (cli *CLI) startNode(nodeID, minerAddress string) {
-	fmt.Printf("Starting node %s\n", nodeID)
+func (cli *CLI) startNode(nodeID, minerAddress, consensus, validatorsFile string) {
+	validators := bc.DefaultValidatorSet()
+	if validatorsFile != "" {
+		var err error
+		validators, err = bc.LoadValidatorSet(validatorsFile)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	// a validator signs its consensus messages with the key of its wallet
+	wallets, _ := wallet.NewWallets(nodeID)
+	key, err := validators.SigningKey(fmt.Sprintf("localhost:%s", nodeID), wallets)
+	if err != nil {
+		log.Panic(err)
+	}
+	engine, err := bc.NewConsensusEngine(consensus, validators, key)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Starting node %s with %s consensus\n", nodeID, consensus)
 	if len(minerAddress) > 0 {
 		if wallet.ValidateAddress(minerAddress) {
 			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
@@ -294,5 +531,5 @@ func (cli *CLI) startNode(nodeID, minerAddress string) {
 			log.Panic("Wrong miner address!")
 		}
 	}
-	bc.StartServer(nodeID, minerAddress)
+	bc.StartServer(nodeID, minerAddress, engine)
 }
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	bc "github.com/zhuaiballl/Go-Tokoin/blockchain"
+)
+
+// submitTx hands the transaction in, or saves it for the other owners to
+// sign while a multi-signature tokoin still lacks signatures
+func submitTx(bchain *bc.Blockchain, tx *bc.Transaction, path string) {
+	submitEnvelope(bc.NewTxEnvelope(tx, bchain), path)
+}
+
+func submitEnvelope(envelope *bc.TxEnvelope, path string) {
+	tx := &envelope.Transaction
+	missing := envelope.MissingSignatures()
+	if missing == 0 {
+		broadcastEnvelope(envelope)
+		return
+	}
+
+	if path == "" {
+		path = fmt.Sprintf("%x.tx", tx.ID)
+	}
+	saveTx(path, envelope)
+	fmt.Printf("Transaction %x needs %d more owner signatures, saved to %s\n", tx.ID, missing, path)
+}
+
+func (cli *CLI) partialSign(address, txFile, nodeID string) {
+	envelope := loadTx(txFile)
+	signEnvelope(envelope, address, nodeID)
+
+	submitEnvelope(envelope, txFile)
+}
+
+func (cli *CLI) combine(txFiles, out, nodeID string) {
+	files := strings.Split(txFiles, ",")
+	envelope := loadTx(files[0])
+	for _, file := range files[1:] {
+		err := envelope.Combine(loadTx(file))
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	submitEnvelope(envelope, out)
+}
@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	bc "github.com/zhuaiballl/Go-Tokoin/blockchain"
+	"github.com/zhuaiballl/Go-Tokoin/utils"
+	"github.com/zhuaiballl/Go-Tokoin/wallet"
+)
+
+// txParams are the buildtx flags, each operation uses some of them
+type txParams struct {
+	to, owner, holder, txId  string
+	time, id, gps, temper    string
+	expiryHeight, expiryTime string
+}
+
+func saveTx(path string, envelope *bc.TxEnvelope) {
+	err := envelope.Save(path)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func loadTx(path string) *bc.TxEnvelope {
+	envelope, err := bc.LoadTxEnvelope(path)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return envelope
+}
+
+// buildTx builds an unsigned transaction to be signed by address and saves it to out
+func (cli *CLI) buildTx(op, address string, params txParams, out, nodeID string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("ERROR: Signer address is not valid")
+	}
+	bchain := bc.NewBlockchain(nodeID)
+	URPOSet := bc.URPOSet{Blockchain: bchain}
+	defer bchain.CloseDB()
+
+	signerKey := utils.Base58Decode([]byte(address))
+	signerKey = signerKey[1 : len(signerKey)-4]
+
+	var outpoint bc.Outpoint
+	if op != "revokeholder" {
+		var err error
+		outpoint, err = bc.ParseOutpoint(params.txId)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	var tx *bc.Transaction
+	switch op {
+	case "deposit":
+		tx = bc.BuildDeposit(signerKey, params.holder, &URPOSet, outpoint)
+	case "transfer":
+		tx = bc.BuildTransfer(signerKey, params.to, &URPOSet, outpoint)
+	case "delegate":
+		tx = bc.BuildDelegate(signerKey, params.to, &URPOSet, outpoint, params.expiryHeight, params.expiryTime)
+	case "edit":
+		tx = bc.BuildEditPolicy(signerKey, &URPOSet, outpoint, params.time, params.id, params.gps, params.temper, params.expiryHeight, params.expiryTime)
+	case "revocat":
+		tx = bc.BuildRevocatTokoin(signerKey, &URPOSet, outpoint)
+	case "revokeholder":
+		tx = bc.BuildRevokeHolder(signerKey, params.owner, params.holder, &URPOSet)
+	case "redeem":
+		ctx := newRedeemContext(params.time, params.id, params.gps, params.temper)
+		tx = bc.BuildRedeemTokoin(signerKey, params.owner, &URPOSet, outpoint, ctx)
+	default:
+		log.Panicf("ERROR: Unknown operation %q", op)
+	}
+
+	if out == "" {
+		out = fmt.Sprintf("%x.tx", tx.ID)
+	}
+	saveTx(out, bc.NewTxEnvelope(tx, bchain))
+	fmt.Printf("Unsigned %s transaction %x saved to %s\n", tx.Type, tx.ID, out)
+}
+
+// signEnvelope signs with the wallet key of address, or with every wallet key
+// that is a signer of the transaction when address is empty
+func signEnvelope(envelope *bc.TxEnvelope, address, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	addresses := wallets.GetAddresses()
+	if address != "" {
+		if !wallet.ValidateAddress(address) {
+			log.Panic("ERROR: Address is not valid")
+		}
+		addresses = []string{address}
+	}
+
+	signed := 0
+	for _, address := range addresses {
+		w := wallets.GetWallet(address)
+		if !envelope.IsSigner(wallet.HashPubKey(w.PublicKey)) {
+			continue
+		}
+		err := envelope.Sign(w.PrivateKey)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Signed by %s\n", address)
+		signed++
+	}
+	if signed == 0 {
+		log.Panicf("ERROR: No key in the wallet can sign transaction %x", envelope.Transaction.ID)
+	}
+}
+
+// signTx signs the transaction file in place, it only needs the wallet file
+func (cli *CLI) signTx(address, txFile, nodeID string) {
+	envelope := loadTx(txFile)
+	signEnvelope(envelope, address, nodeID)
+	saveTx(txFile, envelope)
+
+	fmt.Printf("Transaction %x needs %d more signatures\n", envelope.Transaction.ID, envelope.MissingSignatures())
+}
+
+func (cli *CLI) inspectTx(txFile string) {
+	envelope := loadTx(txFile)
+
+	fmt.Println(envelope.Transaction)
+	for i, out := range envelope.SpentOutputs() {
+		fmt.Printf("Spends input %d, %s:\n", i, envelope.Transaction.Vin[i].Outpoint())
+		out.Show()
+	}
+
+	missing := envelope.MissingSignatures()
+	switch {
+	case envelope.Transaction.IsCoinbase():
+		fmt.Println("Signatures: not needed")
+	case missing > 0:
+		fmt.Printf("Signatures: %d missing\n", missing)
+	case envelope.Verify():
+		fmt.Println("Signatures: complete and valid")
+	default:
+		fmt.Println("Signatures: INVALID")
+	}
+}
+
+func (cli *CLI) broadcastTx(txFile string) {
+	broadcastEnvelope(loadTx(txFile))
+}
+
+// broadcastEnvelope hands in a transaction once it is fully and correctly signed
+func broadcastEnvelope(envelope *bc.TxEnvelope) {
+	tx := &envelope.Transaction
+	if missing := envelope.MissingSignatures(); missing > 0 {
+		log.Panicf("ERROR: Transaction %x needs %d more signatures", tx.ID, missing)
+	}
+	if !envelope.Verify() {
+		log.Panicf("ERROR: Transaction %x has an invalid signature", tx.ID)
+	}
+
+	bc.HandinTx(tx)
+	fmt.Println("Success!")
+}
@@ -21,4 +21,11 @@ func TestBase58(t *testing.T) {
 
 	decoded := Base58Decode([]byte("16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM"))
 	assert.Equal(t, strings.ToLower("00010966776006953D5567439E5E39F86A0D273BEED61967F6"), hex.EncodeToString(decoded))
+
+	// all leading zero bytes survive a round trip
+	hash, err = hex.DecodeString("0000" + rawHash[2:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, hash, Base58Decode(Base58Encode(hash)))
 }
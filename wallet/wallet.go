@@ -31,8 +31,11 @@ func NewWallet() *Wallet {
 
 // GetAddress returns wallet address
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	return KeyHashToAddress(HashPubKey(w.PublicKey))
+}
 
+// KeyHashToAddress encodes a key hash as an address
+func KeyHashToAddress(pubKeyHash []byte) []byte {
 	versionedPayload := append([]byte{version}, pubKeyHash...)
 	checksum := checksum(versionedPayload)
 
@@ -81,7 +84,10 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	// X and Y are padded to a fixed width so that the key can be split in halves
+	pubKey := make([]byte, 64)
+	private.PublicKey.X.FillBytes(pubKey[:32])
+	private.PublicKey.Y.FillBytes(pubKey[32:])
 
 	return *private, pubKey
 }